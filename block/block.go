@@ -0,0 +1,110 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package block
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolBufferSize is the size of the pooled data buffers handed out by Get,
+// large enough for a single datagram of 7 TS packets plus headers.
+const PoolBufferSize = 1500
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, PoolBufferSize)
+	},
+}
+
+// Block is a reference counted chunk of transport stream data, as produced
+// by an input and fanned out to the outputs by the mainloop. Every holder of
+// a reference must call Return when done with it, after which Data must no
+// longer be accessed.
+type Block struct {
+	Data []byte
+	// TimeStamp in 32.32 fixed point NTP format
+	TimeStamp     uint64
+	SeqNo         uint32
+	Discontinuity bool
+	refs          int32
+	buffer        []byte
+	release       func()
+}
+
+// New returns a block holding data, with a reference count of 1
+func New(data []byte) *Block {
+	return &Block{
+		Data:      data,
+		TimeStamp: NTPTime(time.Now()),
+		refs:      1,
+	}
+}
+
+// Get returns a block with a reference count of 1 and a Data buffer of size
+// bytes, taken from a pool when size <= PoolBufferSize.
+func Get(size int) *Block {
+	if size > PoolBufferSize {
+		return New(make([]byte, size))
+	}
+	buffer := bufferPool.Get().([]byte)
+	b := New(buffer[:size])
+	b.buffer = buffer
+	return b
+}
+
+// Wrap returns a block holding data, release is called once the last
+// reference is returned. It allows data owned by another library to be passed
+// through the mainloop without copying.
+func Wrap(data []byte, release func()) *Block {
+	b := New(data)
+	b.release = release
+	return b
+}
+
+// Increment adds a reference
+func (b *Block) Increment() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+// Return drops a reference, the last reference releases the block
+func (b *Block) Return() {
+	refs := atomic.AddInt32(&b.refs, -1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("block returned more often than referenced")
+	}
+	if b.buffer != nil {
+		bufferPool.Put(b.buffer[:PoolBufferSize])
+		b.buffer = nil
+	}
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+	b.Data = nil
+}
+
+const ntpEpochOffset = 2208988800
+
+// NTPTime converts t into the 32.32 fixed point NTP format used for block
+// timestamps
+func NTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// FromNTPTime converts a block timestamp back into a time.Time
+func FromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nsecs := int64(((ts & 0xffffffff) * uint64(time.Second)) >> 32)
+	return time.Unix(secs, nsecs)
+}
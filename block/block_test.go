@@ -0,0 +1,90 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package block
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReferenceCounting(t *testing.T) {
+	b := New([]byte{1, 2, 3})
+	b.Increment()
+	b.Increment()
+	b.Return()
+	b.Return()
+	if b.Data == nil {
+		t.Fatal("block released while still referenced")
+	}
+	b.Return()
+	if b.Data != nil {
+		t.Fatal("block not released after the last reference was returned")
+	}
+}
+
+func TestReturnTooOften(t *testing.T) {
+	b := New([]byte{1})
+	b.Return()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic returning a released block")
+		}
+	}()
+	b.Return()
+}
+
+func TestGetPooled(t *testing.T) {
+	b := Get(188)
+	if len(b.Data) != 188 || cap(b.Data) != PoolBufferSize {
+		t.Fatalf("expected a pooled buffer of 188 bytes, got len %d cap %d", len(b.Data), cap(b.Data))
+	}
+	b.Increment()
+	b.Return()
+	if b.buffer == nil {
+		t.Fatal("buffer returned to the pool while still referenced")
+	}
+	b.Return()
+	if b.buffer != nil || b.Data != nil {
+		t.Fatal("buffer not returned to the pool")
+	}
+	//a buffer from the pool is handed out at the full pool size again
+	b = Get(PoolBufferSize)
+	if len(b.Data) != PoolBufferSize {
+		t.Fatalf("expected %d bytes, got %d", PoolBufferSize, len(b.Data))
+	}
+	b.Return()
+}
+
+func TestGetLarge(t *testing.T) {
+	b := Get(PoolBufferSize + 1)
+	if len(b.Data) != PoolBufferSize+1 || b.buffer != nil {
+		t.Fatal("blocks larger than the pool buffers must not be pooled")
+	}
+	b.Return()
+}
+
+func TestWrapRelease(t *testing.T) {
+	released := 0
+	b := Wrap([]byte{1}, func() { released++ })
+	b.Increment()
+	b.Return()
+	if released != 0 {
+		t.Fatal("released while still referenced")
+	}
+	b.Return()
+	if released != 1 {
+		t.Fatalf("expected 1 release, got %d", released)
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1600000000, 123456789)
+	got := FromNTPTime(NTPTime(now))
+	if d := got.Sub(now); d < -time.Microsecond || d > time.Microsecond {
+		t.Fatalf("expected %s, got %s", now, got)
+	}
+}
@@ -0,0 +1,561 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/logging"
+	"gopkg.in/yaml.v3"
+)
+
+const maxAPIBodySize = 1 << 20
+
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func apiErrorf(status int, format string, args ...interface{}) error {
+	return &apiError{status, fmt.Sprintf(format, args...)}
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*apiError); ok {
+		status = e.status
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// writeConfig writes v as json, using the key names of the yaml config
+func writeConfig(w http.ResponseWriter, status int, v interface{}) {
+	yamlData, err := yaml.Marshal(v)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var generic interface{}
+	if err := yaml.Unmarshal(yamlData, &generic); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	bytes, err := json.Marshal(generic)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
+
+// readConfig decodes a yaml or json request body into v
+func readConfig(r *http.Request, v interface{}) error {
+	dec := yaml.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAPIBodySize))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return apiErrorf(http.StatusBadRequest, "failed to decode body: %s", err)
+	}
+	return nil
+}
+
+// copyFlow returns a copy of c that can be modified without affecting c
+func copyFlow(c *config.Flow) *config.Flow {
+	fc := *c
+	fc.Inputs = append([]config.Input(nil), c.Inputs...)
+	fc.Outputs = append([]config.Output(nil), c.Outputs...)
+	for i, oc := range c.Outputs {
+		if oc.Peers != nil {
+			fc.Outputs[i].Peers = append([]string{}, oc.Peers...)
+		}
+	}
+	if c.Backup != nil {
+		backup := *c.Backup
+		backup.Inputs = append([]config.Input(nil), c.Backup.Inputs...)
+		fc.Backup = &backup
+	}
+	if c.TR101290 != nil {
+		tr101290 := *c.TR101290
+		tr101290.NotOK = append([]string(nil), c.TR101290.NotOK...)
+		fc.TR101290 = &tr101290
+	}
+	if c.Capture != nil {
+		capture := *c.Capture
+		fc.Capture = &capture
+	}
+	return &fc
+}
+
+// changeFlow applies change to the config of flow identifier, change gets a
+// copy of the current config or nil when the flow doesn't exist and returns
+// the new config or nil to delete the flow. The resulting config is validated
+// as a whole before the running flows are updated.
+func changeFlow(ctx context.Context, identifier string, change func(*config.Flow) (*config.Flow, error)) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	newConfig := *runningConfig
+	newConfig.Flows = make([]config.Flow, 0, len(runningConfig.Flows)+1)
+	var current *config.Flow
+	idx := -1
+	for i, fc := range runningConfig.Flows {
+		if fc.Identifier == identifier {
+			current = copyFlow(&fc)
+			idx = i
+		}
+		newConfig.Flows = append(newConfig.Flows, fc)
+	}
+	fc, err := change(current)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fc == nil && idx >= 0:
+		newConfig.Flows = append(newConfig.Flows[:idx], newConfig.Flows[idx+1:]...)
+	case fc == nil:
+		return nil
+	case idx >= 0:
+		newConfig.Flows[idx] = *fc
+	default:
+		newConfig.Flows = append(newConfig.Flows, *fc)
+	}
+	if err := config.ValidateConfig(&newConfig); err != nil {
+		return apiErrorf(http.StatusBadRequest, "%s", err)
+	}
+
+	flowsLock.Lock()
+	defer flowsLock.Unlock()
+	fh, exists := flows[identifier]
+	switch {
+	case fc == nil && exists:
+		logging.Log.Info().Str("identifier", identifier).Msg("deleting flow via api")
+		fh.f.Stop()
+		fh.f.Wait(500 * time.Millisecond)
+		delete(flows, identifier)
+	case fc == nil:
+		//
+	case exists:
+		if err := fh.f.UpdateConfig(fc); err != nil {
+			//the flow may be left half updated or stopped, so re-create it
+			//from the running config, like a reload rolls back
+			if rerr := recreateFlow(ctx, identifier, current); rerr != nil {
+				return fmt.Errorf("%s, rolling back failed: %w", err, rerr)
+			}
+			return err
+		}
+	default:
+		logging.Log.Info().Str("identifier", identifier).Msg("creating flow via api")
+		if err := createFlow(ctx, fc); err != nil {
+			return err
+		}
+	}
+	runningConfig = &newConfig
+
+	if newConfig.API.Persist && configFile != "" {
+		if err := config.SaveToFile(configFile, &newConfig); err != nil {
+			logging.Log.Error().Err(err).Msgf("failed to persist config to %s", configFile)
+			return fmt.Errorf("change applied, but failed to persist config: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkAPIToken requires one of the API tokens when the API is enabled, it
+// writes the error response and returns false when r isn't authorized
+func checkAPIToken(w http.ResponseWriter, r *http.Request) bool {
+	configLock.Lock()
+	var tokens []string
+	needAuth := runningConfig != nil && runningConfig.API != nil
+	if needAuth {
+		tokens = runningConfig.API.Tokens
+	}
+	configLock.Unlock()
+	if needAuth && !authorized(r, tokens) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="streamzeug"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func authorized(r *http.Request, tokens []string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// apiHandler serves the flow management endpoints under /api/flows
+func apiHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		configLock.Lock()
+		var api *config.API
+		if runningConfig != nil {
+			api = runningConfig.API
+		}
+		configLock.Unlock()
+		if api == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorized(r, api.Tokens) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="streamzeug"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		//split before unescaping, identifiers and urls may contain an
+		//escaped slash
+		path := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/api/flows"), "/")
+		var parts []string
+		if path != "" {
+			for _, p := range strings.Split(path, "/") {
+				p, err := url.PathUnescape(p)
+				if err != nil {
+					http.Error(w, "invalid path", http.StatusBadRequest)
+					return
+				}
+				parts = append(parts, p)
+			}
+		}
+		var err error
+		switch len(parts) {
+		case 0:
+			err = apiFlows(ctx, w, r)
+		case 1:
+			err = apiFlow(ctx, w, r, parts[0])
+		case 2, 3:
+			item := ""
+			if len(parts) == 3 {
+				item = parts[2]
+			}
+			switch parts[1] {
+			case "outputs":
+				err = apiOutputs(ctx, w, r, parts[0], item, len(parts) == 3)
+			case "inputs":
+				err = apiInputs(ctx, w, r, parts[0], item, len(parts) == 3)
+			default:
+				err = apiErrorf(http.StatusNotFound, "not found")
+			}
+		default:
+			err = apiErrorf(http.StatusNotFound, "not found")
+		}
+		if err != nil {
+			writeAPIError(w, err)
+		}
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) error {
+	w.Header().Set("Allow", allow)
+	return apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// runningFlow returns a copy of the running config of flow identifier
+func runningFlow(identifier string) (*config.Flow, error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	for _, fc := range runningConfig.Flows {
+		if fc.Identifier == identifier {
+			return copyFlow(&fc), nil
+		}
+	}
+	return nil, apiErrorf(http.StatusNotFound, "flow %s not found", identifier)
+}
+
+func apiFlows(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		configLock.Lock()
+		flows := runningConfig.Flows
+		configLock.Unlock()
+		writeConfig(w, http.StatusOK, flows)
+		return nil
+	case http.MethodPost:
+		var fc config.Flow
+		if err := readConfig(r, &fc); err != nil {
+			return err
+		}
+		err := changeFlow(ctx, fc.Identifier, func(current *config.Flow) (*config.Flow, error) {
+			if current != nil {
+				return nil, apiErrorf(http.StatusConflict, "flow %s already exists", fc.Identifier)
+			}
+			return &fc, nil
+		})
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusCreated, fc)
+		return nil
+	}
+	return methodNotAllowed(w, "GET, POST")
+}
+
+func apiFlow(ctx context.Context, w http.ResponseWriter, r *http.Request, identifier string) error {
+	switch r.Method {
+	case http.MethodGet:
+		fc, err := runningFlow(identifier)
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusOK, fc)
+		return nil
+	case http.MethodPut:
+		var fc config.Flow
+		if err := readConfig(r, &fc); err != nil {
+			return err
+		}
+		if fc.Identifier == "" {
+			fc.Identifier = identifier
+		}
+		if fc.Identifier != identifier {
+			return apiErrorf(http.StatusBadRequest, "identifier %s doesn't match %s", fc.Identifier, identifier)
+		}
+		err := changeFlow(ctx, identifier, func(current *config.Flow) (*config.Flow, error) {
+			if current == nil {
+				return nil, apiErrorf(http.StatusNotFound, "flow %s not found", identifier)
+			}
+			return &fc, nil
+		})
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusOK, fc)
+		return nil
+	case http.MethodDelete:
+		err := changeFlow(ctx, identifier, func(current *config.Flow) (*config.Flow, error) {
+			if current == nil {
+				return nil, apiErrorf(http.StatusNotFound, "flow %s not found", identifier)
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return methodNotAllowed(w, "GET, PUT, DELETE")
+}
+
+func findOutput(fc *config.Flow, identifier string) (int, error) {
+	for i, o := range fc.Outputs {
+		if o.Identifier == identifier {
+			return i, nil
+		}
+	}
+	return -1, apiErrorf(http.StatusNotFound, "output %s not found", identifier)
+}
+
+// apiOutputs serves the outputs of a flow, addressed by their identifier
+func apiOutputs(ctx context.Context, w http.ResponseWriter, r *http.Request, flow, identifier string, single bool) error {
+	if !single {
+		switch r.Method {
+		case http.MethodGet:
+			fc, err := runningFlow(flow)
+			if err != nil {
+				return err
+			}
+			writeConfig(w, http.StatusOK, fc.Outputs)
+			return nil
+		case http.MethodPost:
+			var oc config.Output
+			if err := readConfig(r, &oc); err != nil {
+				return err
+			}
+			if oc.Identifier == "" {
+				return apiErrorf(http.StatusBadRequest, "output must have non-empty identifier")
+			}
+			err := changeFlow(ctx, flow, func(fc *config.Flow) (*config.Flow, error) {
+				if fc == nil {
+					return nil, apiErrorf(http.StatusNotFound, "flow %s not found", flow)
+				}
+				if _, err := findOutput(fc, oc.Identifier); err == nil {
+					return nil, apiErrorf(http.StatusConflict, "output %s already exists", oc.Identifier)
+				}
+				fc.Outputs = append(fc.Outputs, oc)
+				return fc, nil
+			})
+			if err != nil {
+				return err
+			}
+			writeConfig(w, http.StatusCreated, oc)
+			return nil
+		}
+		return methodNotAllowed(w, "GET, POST")
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fc, err := runningFlow(flow)
+		if err != nil {
+			return err
+		}
+		i, err := findOutput(fc, identifier)
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusOK, fc.Outputs[i])
+		return nil
+	case http.MethodPut:
+		var oc config.Output
+		if err := readConfig(r, &oc); err != nil {
+			return err
+		}
+		if oc.Identifier == "" {
+			oc.Identifier = identifier
+		}
+		err := changeFlow(ctx, flow, func(fc *config.Flow) (*config.Flow, error) {
+			if fc == nil {
+				return nil, apiErrorf(http.StatusNotFound, "flow %s not found", flow)
+			}
+			i, err := findOutput(fc, identifier)
+			if err != nil {
+				return nil, err
+			}
+			fc.Outputs[i] = oc
+			return fc, nil
+		})
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusOK, oc)
+		return nil
+	case http.MethodDelete:
+		err := changeFlow(ctx, flow, func(fc *config.Flow) (*config.Flow, error) {
+			if fc == nil {
+				return nil, apiErrorf(http.StatusNotFound, "flow %s not found", flow)
+			}
+			i, err := findOutput(fc, identifier)
+			if err != nil {
+				return nil, err
+			}
+			fc.Outputs = append(fc.Outputs[:i], fc.Outputs[i+1:]...)
+			return fc, nil
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return methodNotAllowed(w, "GET, PUT, DELETE")
+}
+
+func findInput(fc *config.Flow, index string) (int, error) {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(fc.Inputs) {
+		return -1, apiErrorf(http.StatusNotFound, "input %s not found", index)
+	}
+	return i, nil
+}
+
+// apiInputs serves the primary inputs of a flow, addressed by their index
+func apiInputs(ctx context.Context, w http.ResponseWriter, r *http.Request, flow, index string, single bool) error {
+	if !single {
+		switch r.Method {
+		case http.MethodGet:
+			fc, err := runningFlow(flow)
+			if err != nil {
+				return err
+			}
+			writeConfig(w, http.StatusOK, fc.Inputs)
+			return nil
+		case http.MethodPost:
+			var ic config.Input
+			if err := readConfig(r, &ic); err != nil {
+				return err
+			}
+			err := changeFlow(ctx, flow, func(fc *config.Flow) (*config.Flow, error) {
+				if fc == nil {
+					return nil, apiErrorf(http.StatusNotFound, "flow %s not found", flow)
+				}
+				fc.Inputs = append(fc.Inputs, ic)
+				return fc, nil
+			})
+			if err != nil {
+				return err
+			}
+			writeConfig(w, http.StatusCreated, ic)
+			return nil
+		}
+		return methodNotAllowed(w, "GET, POST")
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fc, err := runningFlow(flow)
+		if err != nil {
+			return err
+		}
+		i, err := findInput(fc, index)
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusOK, fc.Inputs[i])
+		return nil
+	case http.MethodPut:
+		var ic config.Input
+		if err := readConfig(r, &ic); err != nil {
+			return err
+		}
+		err := changeFlow(ctx, flow, func(fc *config.Flow) (*config.Flow, error) {
+			if fc == nil {
+				return nil, apiErrorf(http.StatusNotFound, "flow %s not found", flow)
+			}
+			i, err := findInput(fc, index)
+			if err != nil {
+				return nil, err
+			}
+			fc.Inputs[i] = ic
+			return fc, nil
+		})
+		if err != nil {
+			return err
+		}
+		writeConfig(w, http.StatusOK, ic)
+		return nil
+	case http.MethodDelete:
+		err := changeFlow(ctx, flow, func(fc *config.Flow) (*config.Flow, error) {
+			if fc == nil {
+				return nil, apiErrorf(http.StatusNotFound, "flow %s not found", flow)
+			}
+			i, err := findInput(fc, index)
+			if err != nil {
+				return nil, err
+			}
+			fc.Inputs = append(fc.Inputs[:i], fc.Inputs[i+1:]...)
+			return fc, nil
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return methodNotAllowed(w, "GET, PUT, DELETE")
+}
@@ -0,0 +1,87 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/odmedia/streamzeug/flow"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/recording"
+)
+
+// clip requests longer than this are refused
+const maxClipLength = 24 * time.Hour
+
+// parseClipTime accepts RFC 3339 times and unix timestamps in seconds
+func parseClipTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing time")
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// clipHandler serves the captured transport stream of flow f between the
+// start and end query params. When the API is enabled its tokens are
+// required.
+func clipHandler(w http.ResponseWriter, r *http.Request, identifier string, f *flow.Flow) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAPIToken(w, r) {
+		return
+	}
+	start, err := parseClipTime(r.URL.Query().Get("start"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("start: %s", err), http.StatusBadRequest)
+		return
+	}
+	end, err := parseClipTime(r.URL.Query().Get("end"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("end: %s", err), http.StatusBadRequest)
+		return
+	}
+	if !end.After(start) {
+		http.Error(w, "end must be after start", http.StatusBadRequest)
+		return
+	}
+	if end.Sub(start) > maxClipLength {
+		http.Error(w, fmt.Sprintf("clips are limited to %s", maxClipLength), http.StatusBadRequest)
+		return
+	}
+	clip, err := f.Clip(start, end)
+	switch {
+	case errors.Is(err, flow.ErrNoCapture), errors.Is(err, recording.ErrNotRecorded):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		logging.Log.Error().Err(err).Msg("error opening clip")
+		http.Error(w, "error opening clip", http.StatusInternalServerError)
+		return
+	}
+	defer clip.Close()
+	name := fmt.Sprintf("%s-%s-%s.ts", identifier, start.UTC().Format("20060102T150405Z"), end.UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Length", strconv.FormatInt(clip.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, clip); err != nil {
+		logging.Log.Warn().Err(err).Msg("error writing clip")
+	}
+}
@@ -7,43 +7,150 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/output/httpts"
+	"github.com/odmedia/streamzeug/stats"
 )
 
-func startHttpServer(listen string) (*http.Server, error) {
-	srv := &http.Server{Addr: listen}
-
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		status := make(map[string]interface{})
-		status["status"] = "OK"
-		status["OK"] = true
-		statuses := make(map[string]*mainloop.Status)
-		flowsLock.Lock()
-
-		for id, fh := range flows {
-			statuses[id] = fh.f.Status()
-			if !statuses[id].OK {
-				status["status"] = "NOT-OK"
-				status["OK"] = false
-			}
-		}
-		status["flows"] = statuses
-		bytes, err := json.Marshal(status)
-		if err != nil {
-			logging.Log.Error().Err(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte("Unable to marshal to json"))
+func writeJson(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		logging.Log.Error().Err(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("Unable to marshal to json"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(bytes)
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := make(map[string]interface{})
+	status["status"] = "OK"
+	status["OK"] = true
+	statuses := make(map[string]*mainloop.Status)
+	flowsLock.Lock()
+	defer flowsLock.Unlock()
+
+	for id, fh := range flows {
+		statuses[id] = fh.f.Status()
+		if !statuses[id].OK {
+			status["status"] = "NOT-OK"
+			status["OK"] = false
+		}
+	}
+	status["flows"] = statuses
+	writeJson(w, status)
+}
+
+// metricsHandler serves the flow status and stats in the Prometheus text
+// format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var metrics []stats.Metric
+	flowsLock.Lock()
+	for id, fh := range flows {
+		status := fh.f.Snapshot()
+		labels := map[string]string{"identifier": id}
+		ok := 0.0
+		if status.OK {
+			ok = 1
+		}
+		metrics = append(metrics,
+			stats.Metric{Name: "streamzeug_flow_ok", Labels: labels, Value: ok},
+			stats.Metric{Name: "streamzeug_flow_bitrate", Labels: labels, Value: float64(status.Bitrate)},
+			stats.Metric{Name: "streamzeug_flow_packets", Labels: labels, Value: float64(status.PacketCount)},
+			stats.Metric{Name: "streamzeug_flow_ms_since_last_packet", Labels: labels, Value: float64(status.MsSinceLastPacket)},
+			stats.Metric{Name: "streamzeug_flow_outputs", Labels: labels, Value: float64(status.OutputCount)},
+		)
+	}
+	flowsLock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := stats.WritePrometheus(w, metrics); err != nil {
+		logging.Log.Error().Err(err).Msg("error writing metrics")
+	}
+}
+
+// flowsHandler serves the per flow endpoints under /flows/<identifier>/
+func flowsHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/flows/"), "/"), "/")
+	//only /flows/<identifier>/hls/<output identifier>/<file> is nested
+	if len(parts) != 2 && !(len(parts) == 4 && parts[1] == "hls") {
+		http.NotFound(w, r)
+		return
+	}
+	flowsLock.Lock()
+	fh, ok := flows[parts[0]]
+	flowsLock.Unlock()
+	if !ok {
+		http.Error(w, "flow not found", http.StatusNotFound)
+		return
+	}
+	switch parts[1] {
+	case "forcesource":
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkAPIToken(w, r) {
+			return
+		}
+		source := r.URL.Query().Get("source")
+		if err := fh.f.ForceSource(source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logging.Log.Info().Str("identifier", parts[0]).Msgf("source forced to %s via http", source)
+		writeJson(w, map[string]string{"identifier": parts[0], "forcedsource": source})
+	case "clip":
+		clipHandler(w, r, parts[0], fh.f)
+	case "stream.ts":
+		h, ok := fh.f.StreamHandler()
+		if !ok {
+			http.Error(w, "flow has no httpts output", http.StatusNotFound)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		_, _ = w.Write(bytes)
-		flowsLock.Unlock()
-	})
+		h.ServeHTTP(w, r)
+	case "hls":
+		h, ok := fh.f.OutputHandler(parts[2])
+		if !ok {
+			http.Error(w, "hls output not found", http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func startHttpServer(ctx context.Context, listen string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	//requests are cancelled on shutdown, ending the httpts streams which
+	//would otherwise keep the server from shutting down
+	basectx, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:        listen,
+		Handler:     mux,
+		ConnContext: httpts.ConnContext,
+		BaseContext: func(net.Listener) context.Context { return basectx },
+	}
+	srv.RegisterOnShutdown(cancel)
+
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/flows/", flowsHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/plan", planHandler)
+	mux.HandleFunc("/reload", reloadHandler)
+	mux.HandleFunc("/api/flows", apiHandler(ctx))
+	mux.HandleFunc("/api/flows/", apiHandler(ctx))
 	ec := make(chan error)
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
@@ -52,6 +159,7 @@ func startHttpServer(listen string) (*http.Server, error) {
 	}()
 	select {
 	case err := <-ec:
+		cancel()
 		return nil, err
 	case <-time.After(1 * time.Millisecond):
 		return srv, nil
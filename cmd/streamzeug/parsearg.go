@@ -35,20 +35,26 @@ func parseArguments() (*config.Config, error) {
 		inputs, outputs                        arrayFlags
 		ristRecoverySize, statsIntervalSeconds int
 		statsFile                              string
+		inputType                              string
 		influxDBUrl                            string
 		influxDBToken                          string
 		influxDBOrg                            string
 		influxDBBucket                         string
 		influxDBIdentifier                     string
 		configTest                             bool
+		plan                                   bool
+		planJSON                               bool
 		statsStdOut                            bool
 		conf                                   *config.Config
 		showVersion                            bool
 	)
 	flag.StringVar(&configFile, "configfile", "", "config file")
 	flag.BoolVar(&configTest, "configtest", false, "don't load config, just validate it")
+	flag.BoolVar(&plan, "plan", false, "don't load config, print the changes reloading it would make to the instance running on its listenhttp")
+	flag.BoolVar(&planJSON, "plan-json", false, "print the plan as json")
 	flag.Var(&inputs, "input", "input url, multiple instances of -input may be defined, with a minimum of 1")
 	flag.Var(&outputs, "output", "output url, multiple instances of -output may be defined, with a minimum of 1")
+	flag.StringVar(&inputType, "input-type", "RIST", "input type, RIST, SRT or UDP")
 	flag.IntVar(&ristRecoverySize, "rist-recoverysize", 1000, "recovery buffer size in ms")
 	flag.IntVar(&statsIntervalSeconds, "stats-interval", 10, "stats reporting interval in seconds")
 	flag.StringVar(&statsFile, "stats-file", "", "base name for stats file")
@@ -68,6 +74,9 @@ func parseArguments() (*config.Config, error) {
 	if configTest && configFile == "" {
 		return nil, errors.New("cannot test config without configfile")
 	}
+	if plan && configFile == "" {
+		return nil, errors.New("cannot plan config without configfile")
+	}
 
 	stats.StatsIntervalSeconds = statsIntervalSeconds
 	if configFile == "" {
@@ -89,11 +98,13 @@ func parseArguments() (*config.Config, error) {
 		}
 
 		flowConf := config.Flow{
-			Identifier:  influxDBIdentifier,
-			InputType:   "RIST",
-			RistProfile: libristwrapper.RistProfileSimple,
-			Latency:     ristRecoverySize,
-			StreamID:    0,
+			Identifier: influxDBIdentifier,
+			Source: config.Source{
+				InputType:   strings.ToUpper(inputType),
+				RistProfile: libristwrapper.RistProfileSimple,
+				Latency:     ristRecoverySize,
+				StreamID:    0,
+			},
 			StatsStdOut: statsStdOut,
 			StatsFile:   statsFile,
 		}
@@ -132,5 +143,11 @@ func parseArguments() (*config.Config, error) {
 		logging.Log.Info().Msg("config OK")
 		os.Exit(0)
 	}
+	if plan {
+		if err := requestPlan(configFile, conf.ListenHTTP, planJSON); err != nil {
+			return nil, fmt.Errorf("failed to get plan: %w", err)
+		}
+		os.Exit(0)
+	}
 	return conf, nil
 }
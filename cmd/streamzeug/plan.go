@@ -0,0 +1,187 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/flow"
+)
+
+// configPlan describes what reloading a config does
+type configPlan struct {
+	Unchanged  bool         `json:"unchanged"`
+	InfluxDB   bool         `json:"influxdb"`
+	ListenHTTP bool         `json:"listenhttp"`
+	API        bool         `json:"api"`
+	Flows      []*flow.Plan `json:"flows"`
+	influxdb   *config.InfluxDBConfig
+	listenhttp string
+}
+
+func (p *configPlan) String() string {
+	if p.Unchanged {
+		return "config unchanged\n"
+	}
+	var b strings.Builder
+	if p.InfluxDB {
+		if p.influxdb == nil {
+			b.WriteString("influxdb: disable\n")
+		} else {
+			fmt.Fprintf(&b, "influxdb: reconfigure to %s\n", p.influxdb.Url)
+		}
+	}
+	if p.ListenHTTP {
+		if p.listenhttp == "" {
+			b.WriteString("http server: stop\n")
+		} else {
+			fmt.Fprintf(&b, "http server: restart on %s\n", p.listenhttp)
+		}
+	}
+	if p.API {
+		b.WriteString("api: settings changed\n")
+	}
+	for _, f := range p.Flows {
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// planConfig returns the changes reloadConfigfile makes to apply conf, in the
+// order they are applied. configLock must be held.
+func planConfig(conf *config.Config) *configPlan {
+	p := &configPlan{
+		Unchanged:  reflect.DeepEqual(runningConfig, conf),
+		InfluxDB:   !reflect.DeepEqual(runningConfig.InfluxDB, conf.InfluxDB),
+		ListenHTTP: runningConfig.ListenHTTP != conf.ListenHTTP,
+		API:        !reflect.DeepEqual(runningConfig.API, conf.API),
+		influxdb:   conf.InfluxDB,
+		listenhttp: conf.ListenHTTP,
+	}
+	flowsLock.Lock()
+	defer flowsLock.Unlock()
+	keep := make(map[string]int)
+	for _, fc := range conf.Flows {
+		keep[fc.Identifier] = 1
+	}
+	var deleted []string
+	for id := range flows {
+		if _, ok := keep[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(deleted)
+	for _, id := range deleted {
+		p.Flows = append(p.Flows, flows[id].f.PlanDelete())
+	}
+	for _, fc := range conf.Flows {
+		if fh, ok := flows[fc.Identifier]; ok {
+			p.Flows = append(p.Flows, fh.f.PlanUpdate(&fc))
+		} else {
+			p.Flows = append(p.Flows, flow.PlanNew(&fc))
+		}
+	}
+	return p
+}
+
+// planHandler returns the plan for reloading the config file, or for the
+// config posted as body, without applying it
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		conf *config.Config
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		if configFile == "" {
+			http.Error(w, "not running from a config file", http.StatusBadRequest)
+			return
+		}
+		conf, err = config.LoadFromFile(configFile)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read configfile: %s", err), http.StatusInternalServerError)
+			return
+		}
+	case http.MethodPost:
+		conf = new(config.Config)
+		if err := readConfig(r, conf); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := config.ValidateConfig(conf); err != nil {
+		http.Error(w, fmt.Sprintf("config validation failed: %s", err), http.StatusBadRequest)
+		return
+	}
+	configLock.Lock()
+	if runningConfig == nil {
+		configLock.Unlock()
+		http.Error(w, "not running", http.StatusServiceUnavailable)
+		return
+	}
+	plan := planConfig(conf)
+	configLock.Unlock()
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(plan.String()))
+		return
+	}
+	writeJson(w, plan)
+}
+
+// requestPlan posts filename to the plan endpoint of the instance listening
+// on listen and prints the resulting plan
+func requestPlan(filename, listen string, asJSON bool) error {
+	if listen == "" {
+		return errors.New("plan requires listenhttp to reach the running instance")
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	yamlData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	u := "http://" + net.JoinHostPort(host, port) + "/plan"
+	if !asJSON {
+		u += "?format=text"
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(u, "application/yaml", bytes.NewReader(yamlData))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = os.Stdout.Write(body)
+	return err
+}
@@ -0,0 +1,267 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/flow"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/stats"
+)
+
+// Outcomes of a reload
+const (
+	reloadSuccess    = "success"
+	reloadUnchanged  = "unchanged"
+	reloadFailed     = "failed"
+	reloadRolledBack = "rolledback"
+	reloadPartial    = "partial"
+)
+
+// reloadStatus reports the outcome of a reload. On failed nothing was
+// changed, on rolledback the changes made were undone and on partial undoing
+// them failed as well, leaving some changes applied.
+type reloadStatus struct {
+	Time           time.Time   `json:"time"`
+	Outcome        string      `json:"outcome"`
+	Error          string      `json:"error,omitempty"`
+	RollbackErrors []string    `json:"rollbackerrors,omitempty"`
+	Plan           *configPlan `json:"plan,omitempty"`
+}
+
+var (
+	reloadLock sync.Mutex
+	lastReload *reloadStatus
+)
+
+// undoStack collects the undo actions of the applied changes of a reload
+type undoStack []func() error
+
+func (u *undoStack) push(f func() error) {
+	*u = append(*u, f)
+}
+
+// rollback undoes the applied changes in reverse order, continuing on errors
+func (u undoStack) rollback() []string {
+	var errs []string
+	for i := len(u) - 1; i >= 0; i-- {
+		if err := u[i](); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return errs
+}
+
+func setupInflux(ctx context.Context, c *config.InfluxDBConfig, identifier string) error {
+	influxcancel()
+	var influxctx context.Context
+	influxctx, influxcancel = context.WithCancel(ctx)
+	if c == nil {
+		stats.InfluxDisable()
+		return nil
+	}
+	return stats.SetupInfluxDB(influxctx, c, identifier)
+}
+
+func restartHttpServer(ctx context.Context, listen string) error {
+	if httpsrv != nil {
+		shutdownctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := httpsrv.Shutdown(shutdownctx); err != nil {
+			//the streams are ending but their connections may not be
+			//closed yet
+			if err := httpsrv.Close(); err != nil {
+				return fmt.Errorf("error stopping webserver: %w", err)
+			}
+		}
+		httpsrv = nil
+	}
+	if listen == "" {
+		return nil
+	}
+	srv, err := startHttpServer(ctx, listen)
+	if err != nil {
+		return fmt.Errorf("failed to start webserver: %w", err)
+	}
+	httpsrv = srv
+	return nil
+}
+
+// recreateFlow replaces flow identifier by a new flow created from c, or
+// only stops it when c is nil. flowsLock must be held.
+func recreateFlow(ctx context.Context, identifier string, c *config.Flow) error {
+	if fh, ok := flows[identifier]; ok {
+		fh.f.Stop()
+		fh.f.Wait(500 * time.Millisecond)
+		delete(flows, identifier)
+	}
+	if c == nil {
+		return nil
+	}
+	if err := createFlow(ctx, c); err != nil {
+		return fmt.Errorf("couldn't restore flow %s: %w", identifier, err)
+	}
+	return nil
+}
+
+// applyConfigPlan applies conf following plan, pushing the undo action of
+// every change made onto undo. configLock must be held.
+func applyConfigPlan(ctx context.Context, conf *config.Config, plan *configPlan, undo *undoStack) error {
+	prev := runningConfig
+	if plan.InfluxDB {
+		err := setupInflux(ctx, conf.InfluxDB, conf.Identifier)
+		undo.push(func() error {
+			return setupInflux(ctx, prev.InfluxDB, prev.Identifier)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reconfigure influxdb: %w", err)
+		}
+	}
+
+	if plan.ListenHTTP {
+		err := restartHttpServer(ctx, conf.ListenHTTP)
+		undo.push(func() error {
+			return restartHttpServer(ctx, prev.ListenHTTP)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	prevFlows := make(map[string]*config.Flow)
+	for i := range prev.Flows {
+		prevFlows[prev.Flows[i].Identifier] = &prev.Flows[i]
+	}
+
+	flowsLock.Lock()
+	defer flowsLock.Unlock()
+
+	//delete first, as flow might use same inputs/outputs
+	for _, p := range plan.Flows {
+		if p.Action != flow.PlanDelete {
+			continue
+		}
+		id := p.Identifier
+		if err := recreateFlow(ctx, id, nil); err != nil {
+			return err
+		}
+		undo.push(func() error {
+			return recreateFlow(ctx, id, prevFlows[id])
+		})
+	}
+
+	for i := range conf.Flows {
+		fc := &conf.Flows[i]
+		id := fc.Identifier
+		fh, ok := flows[id]
+		if !ok {
+			err := createFlow(ctx, fc)
+			if err != nil {
+				return fmt.Errorf("couldn't create flow %s: %w", id, err)
+			}
+			undo.push(func() error {
+				return recreateFlow(ctx, id, nil)
+			})
+			continue
+		}
+		if err := fh.f.UpdateConfig(fc); err != nil {
+			//the flow may be left half updated or stopped, so re-create it
+			undo.push(func() error {
+				return recreateFlow(ctx, id, prevFlows[id])
+			})
+			return fmt.Errorf("error updating flow %s config: %w", id, err)
+		}
+		undo.push(func() error {
+			prevfc, ok := prevFlows[id]
+			if !ok {
+				return recreateFlow(ctx, id, nil)
+			}
+			if err := fh.f.UpdateConfig(prevfc); err != nil {
+				return recreateFlow(ctx, id, prevfc)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+func setLastReload(status *reloadStatus) {
+	reloadLock.Lock()
+	lastReload = status
+	reloadLock.Unlock()
+}
+
+// reloadConfigfile applies the config file, either all changes are applied
+// or the running config is restored
+func reloadConfigfile(ctx context.Context) *reloadStatus {
+	status := &reloadStatus{Time: time.Now()}
+	defer setLastReload(status)
+
+	conf, err := config.LoadFromFile(configFile)
+	if err != nil {
+		logging.Log.Error().Err(err).Msg("failed to read configfile")
+		status.Outcome = reloadFailed
+		status.Error = fmt.Sprintf("failed to read configfile: %s", err)
+		return status
+	}
+
+	if err := config.ValidateConfig(conf); err != nil {
+		logging.Log.Error().Err(err).Msgf("failed to validate config file, not reloading: %s", err)
+		status.Outcome = reloadFailed
+		status.Error = fmt.Sprintf("failed to validate config file: %s", err)
+		return status
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	plan := planConfig(conf)
+	status.Plan = plan
+	if plan.Unchanged {
+		logging.Log.Info().Msg("config unchanged")
+		status.Outcome = reloadUnchanged
+		return status
+	}
+
+	var undo undoStack
+	if err := applyConfigPlan(ctx, conf, plan, &undo); err != nil {
+		status.Error = err.Error()
+		logging.Log.Error().Err(err).Msg("failed to apply config, rolling back")
+		status.RollbackErrors = undo.rollback()
+		if len(status.RollbackErrors) > 0 {
+			status.Outcome = reloadPartial
+			logging.Log.Error().Strs("errors", status.RollbackErrors).Msg("rollback failed, config partially applied")
+			return status
+		}
+		status.Outcome = reloadRolledBack
+		logging.Log.Warn().Msg("rolled back to previous config")
+		return status
+	}
+
+	runningConfig = conf
+	status.Outcome = reloadSuccess
+	logging.Log.Info().Msg("config reloaded")
+	return status
+}
+
+// reloadHandler reports the outcome of the last reload
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	reloadLock.Lock()
+	status := lastReload
+	reloadLock.Unlock()
+	if status == nil {
+		http.Error(w, "no reload done", http.StatusNotFound)
+		return
+	}
+	writeJson(w, status)
+}
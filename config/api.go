@@ -0,0 +1,29 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package config
+
+import "errors"
+
+// API enables the flow management endpoints of the http server
+type API struct {
+	// bearer tokens granting access to the API
+	Tokens []string `yaml:"tokens"`
+	// write the running config back to the config file after every change
+	Persist bool `yaml:"persist"`
+}
+
+func validateAPI(c *API) error {
+	if len(c.Tokens) == 0 {
+		return errors.New("at least 1 token required")
+	}
+	for _, t := range c.Tokens {
+		if t == "" {
+			return errors.New("empty token not allowed")
+		}
+	}
+	return nil
+}
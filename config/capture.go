@@ -0,0 +1,55 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+const DefaultCaptureWindow = 7200
+
+// Capture keeps a rolling recording of the flow on disk, clips of it are
+// served on /flows/<identifier>/clip
+type Capture struct {
+	Dir string `yaml:"dir"`
+	// seconds of the flow kept, defaults to DefaultCaptureWindow
+	WindowSeconds int `yaml:"window,omitempty"`
+}
+
+func validateCapture(c *Flow) error {
+	if c.Capture.Dir == "" {
+		return errors.New("capture dir must be set")
+	}
+	if !filepath.IsAbs(c.Capture.Dir) {
+		return errors.New("capture dir must be an absolute path")
+	}
+	if c.Capture.WindowSeconds < 0 {
+		return errors.New("window must be positive")
+	}
+	fi, err := os.Stat(c.Capture.Dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", c.Capture.Dir)
+	}
+	//file outputs of the flow would write segments with the same names
+	for _, o := range c.Outputs {
+		u, err := url.Parse(o.Url)
+		if err != nil {
+			continue
+		}
+		if u.Scheme == "file" && filepath.Clean(u.Path) == filepath.Clean(c.Capture.Dir) {
+			return fmt.Errorf("dir %s is also used by output %s", c.Capture.Dir, o.Url)
+		}
+	}
+	return nil
+}
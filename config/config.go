@@ -10,6 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,6 +20,7 @@ type Config struct {
 	Identifier string          `yaml:"identifier"`
 	ListenHTTP string          `yaml:"listenhttp"`
 	InfluxDB   *InfluxDBConfig `yaml:"influxdb,omitempty"`
+	API        *API            `yaml:"api,omitempty"`
 	Flows      []Flow          `yaml:"flows"`
 }
 
@@ -38,6 +41,14 @@ func ValidateConfig(c *Config) error {
 	if err := ValidateInfluxDBConfig(c.InfluxDB); err != nil {
 		return fmt.Errorf("influx-db validation failed: %w", err)
 	}
+	if c.API != nil {
+		if c.ListenHTTP == "" {
+			return errors.New("api requires listenhttp")
+		}
+		if err := validateAPI(c.API); err != nil {
+			return fmt.Errorf("api validation failed: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -53,3 +64,36 @@ func LoadFromFile(filename string) (*Config, error) {
 	}
 	return &conf, nil
 }
+
+// SaveToFile atomically replaces filename with c, by writing to a temporary
+// file in the same directory and renaming it
+func SaveToFile(filename string, c *Config) error {
+	yamlData, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(yamlData); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
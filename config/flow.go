@@ -9,44 +9,102 @@ package config
 import (
 	"errors"
 	"fmt"
-	"math"
+	"net/url"
 	"os"
-
-	"code.videolan.org/rist/ristgo/libristwrapper"
 )
 
 type Flow struct {
-	Identifier      string                     `yaml:"identifier"`
-	InputType       string                     `yaml:"type"`
-	RistProfile     libristwrapper.RistProfile `yaml:"ristprofile"`
-	Latency         int                        `yaml:"latency"`
-	StreamID        int                        `yaml:"streamid"`
-	Inputs          []Input                    `yaml:"inputs"`
-	Outputs         []Output                   `yaml:"outputs"`
-	StatsStdOut     bool                       `yaml:"statsstdout"`
-	StatsFile       string                     `yaml:"statsfile"`
-	MinimalBitrate  int                        `yaml:"minimalbitrate"`
-	MaxPacketTimeMS int                        `yaml:"maxpackettime"`
+	Identifier      string `yaml:"identifier"`
+	Source          `yaml:",inline"`
+	Backup          *Backup   `yaml:"backup,omitempty"`
+	Outputs         []Output  `yaml:"outputs"`
+	StatsStdOut     bool      `yaml:"statsstdout"`
+	StatsFile       string    `yaml:"statsfile"`
+	MinimalBitrate  int       `yaml:"minimalbitrate"`
+	MaxPacketTimeMS int       `yaml:"maxpackettime"`
+	TR101290        *TR101290 `yaml:"tr101290,omitempty"`
+	Capture         *Capture  `yaml:"capture,omitempty"`
 }
 
-func ValidateFlowConfig(c *Flow) error {
-	if len(c.Inputs) < 1 {
-		return errors.New("at least 1 input required")
+// Backup is a second source for a flow, in failover mode the flow fails
+// over to it when the primary source goes silent or drops under
+// MinimalBitrate, in merge mode packets of both sources are merged by
+// sequence number (SMPTE 2022-7).
+type Backup struct {
+	Source `yaml:",inline"`
+	// failover (default) or merge
+	Mode string `yaml:"mode"`
+	// ms without packets from the primary before failing over
+	SilenceMS int `yaml:"silence"`
+	// ms the primary has to be healthy before switching back to it
+	HoldOffMS int `yaml:"holdoff"`
+	// ms packets are held in merge mode waiting for the other source
+	SkewMS int `yaml:"skew"`
+}
+
+// sequenced returns wether the packets of c carry a sequence number shared
+// by redundant senders
+func (c *Source) sequenced() bool {
+	switch c.InputType {
+	case "RIST":
+		return true
+	case "UDP":
+		u, err := url.Parse(c.Inputs[0].Url)
+		return err == nil && u.Scheme == "rtp"
+	}
+	return false
+}
+
+func validateBackup(c *Flow) error {
+	if err := validateSource(&c.Backup.Source); err != nil {
+		return err
 	}
+	if err := checkDuplicates(append(append([]Input{}, c.Inputs...), c.Backup.Inputs...)); err != nil {
+		return err
+	}
+	if c.Backup.SilenceMS < 0 || c.Backup.HoldOffMS < 0 || c.Backup.SkewMS < 0 {
+		return errors.New("backup silence, holdoff and skew must be positive")
+	}
+	switch c.Backup.Mode {
+	case "", "failover":
+		//
+	case "merge":
+		if !c.Source.sequenced() || !c.Backup.Source.sequenced() {
+			return errors.New("merge mode requires RIST or RTP inputs")
+		}
+	default:
+		return fmt.Errorf("mode %s not supported, must be failover or merge", c.Backup.Mode)
+	}
+	return nil
+}
 
-	if err := checkDuplicates(c.Inputs); err != nil {
+func ValidateFlowConfig(c *Flow) error {
+	if err := validateSource(&c.Source); err != nil {
 		return err
 	}
 
-	for _, i := range c.Inputs {
-		if err := validateInputConfig(&i); err != nil {
-			return fmt.Errorf("input validation failed: %w", err)
+	if c.Backup != nil {
+		if err := validateBackup(c); err != nil {
+			return fmt.Errorf("backup validation failed: %w", err)
+		}
+	}
+
+	if c.TR101290 != nil {
+		if err := validateTR101290(c.TR101290); err != nil {
+			return fmt.Errorf("tr101290 validation failed: %w", err)
 		}
 	}
+
 	if err := checkDuplicates(c.Outputs); err != nil {
 		return err
 	}
 
+	if c.Capture != nil {
+		if err := validateCapture(c); err != nil {
+			return fmt.Errorf("capture validation failed: %w", err)
+		}
+	}
+
 	for _, o := range c.Outputs {
 		if err := validateOutputConfig(&o); err != nil {
 			return fmt.Errorf("output validation failed: %w", err)
@@ -57,26 +115,12 @@ func ValidateFlowConfig(c *Flow) error {
 		return errors.New("flow must have non-empty Identifier")
 	}
 
-	if c.InputType != "RIST" {
-		return errors.New("only Type RIST supported atm")
-	}
-
-	if c.RistProfile > libristwrapper.RistProfileMain {
-		return errors.New("invalid RistProfile")
-	}
-
 	if c.StatsFile != "" {
 		if _, err := os.Stat(c.StatsFile); err != nil {
 			return fmt.Errorf("statssfile: %s error: %w", c.StatsFile, err)
 		}
 	}
 
-	if c.InputType == "RIST" {
-		if c.StreamID > math.MaxUint16 {
-			return fmt.Errorf("StreamID: %d must be smaller than: %d", c.StreamID, math.MaxUint16)
-		}
-	}
-
 	if c.MaxPacketTimeMS > 0 && c.MinimalBitrate == 0 || c.MinimalBitrate > 0 && c.MaxPacketTimeMS == 0 {
 		return errors.New("when using MaxpacketTime or MinimalBitrate both have to be set higher than 0")
 	}
@@ -14,6 +14,12 @@ type InfluxDBConfig struct {
 	SrtMeasurement         string `yaml:"srt"`
 	RistRXMeasurement      string `yaml:"ristrx"`
 	RistTXMeasurement      string `yaml:"risttx"`
+	UdpRXMeasurement       string `yaml:"udprx"`
+	FailoverMeasurement    string `yaml:"failover"`
+	MergeMeasurement       string `yaml:"merge"`
+	TR101290Measurement    string `yaml:"tr101290"`
+	PIDMeasurement         string `yaml:"pid"`
+	ClientMeasurement      string `yaml:"client"`
 	ApplicationMeasurement string `yaml:"application"`
 }
 
@@ -6,6 +6,26 @@
 
 package config
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/odmedia/streamzeug/fec"
+)
+
+// Source describes the input side of a flow
+type Source struct {
+	InputType   string                     `yaml:"type"`
+	RistProfile libristwrapper.RistProfile `yaml:"ristprofile"`
+	Latency     int                        `yaml:"latency"`
+	StreamID    int                        `yaml:"streamid"`
+	Inputs      []Input                    `yaml:"inputs"`
+}
+
 type Input struct {
 	Url string `yaml:"url"`
 }
@@ -13,3 +33,64 @@ type Input struct {
 func validateInputConfig(c *Input) error {
 	return validateURL(c.Url)
 }
+
+func validateInputScheme(inputType string, c *Input) error {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return err
+	}
+	if inputType == "UDP" && u.Scheme == "rtp" {
+		if _, err := fec.ParseParams(u); err != nil {
+			return fmt.Errorf("input %s: %w", c.Url, err)
+		}
+		return nil
+	}
+	if u.Query().Get("fec") != "" {
+		return fmt.Errorf("input %s: fec requires an rtp input", c.Url)
+	}
+	if u.Scheme != strings.ToLower(inputType) {
+		return fmt.Errorf("input %s not supported for Type %s", c.Url, inputType)
+	}
+	return nil
+}
+
+func validateSource(c *Source) error {
+	if len(c.Inputs) < 1 {
+		return errors.New("at least 1 input required")
+	}
+
+	if err := checkDuplicates(c.Inputs); err != nil {
+		return err
+	}
+
+	for _, i := range c.Inputs {
+		if err := validateInputConfig(&i); err != nil {
+			return fmt.Errorf("input validation failed: %w", err)
+		}
+	}
+
+	switch c.InputType {
+	case "RIST":
+		//
+	case "SRT", "UDP":
+		if len(c.Inputs) != 1 {
+			return fmt.Errorf("Type %s requires exactly 1 input", c.InputType)
+		}
+		if err := validateInputScheme(c.InputType, &c.Inputs[0]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Type %s not supported, must be RIST, SRT or UDP", c.InputType)
+	}
+
+	if c.RistProfile > libristwrapper.RistProfileMain {
+		return errors.New("invalid RistProfile")
+	}
+
+	if c.InputType == "RIST" {
+		if c.StreamID > math.MaxUint16 {
+			return fmt.Errorf("StreamID: %d must be smaller than: %d", c.StreamID, math.MaxUint16)
+		}
+	}
+	return nil
+}
@@ -7,13 +7,29 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/odmedia/streamzeug/fec"
+	"github.com/odmedia/streamzeug/recording"
 )
 
 type Output struct {
-	Identifier string `yaml:"identifier"`
-	Url        string `yaml:"url"`
+	Identifier string   `yaml:"identifier"`
+	Url        string   `yaml:"url"`
+	Peers      []string `yaml:"peers,omitempty"`
+	QueueDepth int      `yaml:"queuedepth,omitempty"`
+	// consecutive restarts of a failed output before giving up, 0 retries
+	// forever
+	MaxRestarts int `yaml:"maxrestarts,omitempty"`
+	// ms the output plays out behind the flow
+	DelayMS int `yaml:"delay,omitempty"`
+	// directory spooling delays over 30s to disk, defaults to the system
+	// temp directory
+	SpoolDir string `yaml:"spooldir,omitempty"`
 }
 
 func validateOutputConfig(c *Output) error {
@@ -24,10 +40,108 @@ func validateOutputConfig(c *Output) error {
 	if err != nil {
 		panic(err) //if url parsing goes bad after doing the same in validateURL, panic
 	}
+	if c.QueueDepth < 0 {
+		return errors.New("queuedepth must be positive")
+	}
+	if c.MaxRestarts < 0 {
+		return errors.New("maxrestarts must be positive")
+	}
+	if c.DelayMS < 0 {
+		return errors.New("delay must be positive")
+	}
+	if c.SpoolDir != "" {
+		if fi, err := os.Stat(c.SpoolDir); err != nil {
+			return fmt.Errorf("spooldir: %w", err)
+		} else if !fi.IsDir() {
+			return fmt.Errorf("spooldir: %s is not a directory", c.SpoolDir)
+		}
+	}
+	if len(c.Peers) > 0 && u.Scheme != "rist" {
+		return fmt.Errorf("peers not supported for output type %s", u.Scheme)
+	}
 	switch u.Scheme {
-	case "srt", "udp", "rtp", "dektecasi":
+	case "srt", "dektecasi":
+		return nil
+	case "udp", "rtp":
+		p, err := fec.ParseParams(u)
+		if err != nil {
+			return fmt.Errorf("output %s: %w", c.Url, err)
+		}
+		if p != nil && u.Scheme != "rtp" {
+			return fmt.Errorf("output %s: fec requires rtp", c.Url)
+		}
+		return nil
+	case "rist":
+		for _, p := range c.Peers {
+			if err := validateURL(p); err != nil {
+				return err
+			}
+			if pu, _ := url.Parse(p); pu.Scheme != "rist" {
+				return fmt.Errorf("rist peer %s must use rist scheme", p)
+			}
+		}
+		return nil
+	case "file":
+		if _, err := recording.ParseURL(u); err != nil {
+			return fmt.Errorf("file output %s: %w", c.Url, err)
+		}
+		return nil
+	case "hls":
+		if err := validateHLS(u); err != nil {
+			return fmt.Errorf("hls output %s: %w", c.Url, err)
+		}
+		return nil
+	case "tcp":
+		if _, err := strconv.Atoi(u.Port()); err != nil {
+			return fmt.Errorf("tcp output %s must have a port", c.Url)
+		}
+		return nil
+	case "httpts":
+		if err := validateHttpTs(u); err != nil {
+			return fmt.Errorf("httpts output %s: %w", c.Url, err)
+		}
 		return nil
 	default:
 		return fmt.Errorf("output type %s not supported", u.Scheme)
 	}
 }
+
+// validateHLS checks hls:///dir and hls://?segment=6&window=6 urls
+func validateHLS(u *url.URL) error {
+	if u.Host != "" {
+		return fmt.Errorf("hls url must have no host, got %s", u.Host)
+	}
+	for _, param := range []string{"segment", "window"} {
+		if v := u.Query().Get(param); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 1 {
+				return fmt.Errorf("%s must be a positive number: %s", param, v)
+			}
+		}
+	}
+	if u.Path == "" {
+		return nil
+	}
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", u.Path)
+	}
+	return nil
+}
+
+// validateHttpTs checks httpts://?maxclients=10&queue=1024 urls
+func validateHttpTs(u *url.URL) error {
+	if u.Host != "" || u.Path != "" {
+		return errors.New("httpts url must have no host or path, it is served on /flows/<identifier>/stream.ts")
+	}
+	for _, param := range []string{"maxclients", "queue"} {
+		if v := u.Query().Get(param); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 1 {
+				return fmt.Errorf("%s must be a positive number: %s", param, v)
+			}
+		}
+	}
+	return nil
+}
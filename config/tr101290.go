@@ -0,0 +1,38 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/odmedia/streamzeug/tsanalyzer"
+)
+
+// TR101290 configures which TR 101 290 errors set a flow NOT-OK
+type TR101290 struct {
+	// priority 1 errors as named in TR 101 290, e.g. TS_sync_loss
+	NotOK []string `yaml:"notok"`
+	// ms a flow stays NOT-OK after such an error, defaults to 5000
+	HoldMS int `yaml:"hold"`
+}
+
+func validateTR101290(c *TR101290) error {
+	for _, name := range c.NotOK {
+		e, ok := tsanalyzer.ParseErrorType(name)
+		if !ok {
+			return fmt.Errorf("unknown error: %s", name)
+		}
+		if e.Priority() != 1 {
+			return fmt.Errorf("%s is not a priority 1 error", name)
+		}
+	}
+	if c.HoldMS < 0 {
+		return errors.New("hold must be positive")
+	}
+	return nil
+}
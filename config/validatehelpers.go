@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"path"
 	"reflect"
 )
 
@@ -83,11 +84,21 @@ func checkDuplicates(val interface{}) error {
 		if _, ok := check[key]; ok {
 			return fmt.Errorf("duplicate url: %s in %s", key, name)
 		}
-		if u != nil {
-			if _, ok := check[u.Host]; ok {
-				return fmt.Errorf("duplicate url: %s in %s", u.Host, name)
+		if u != nil && !(u.Scheme == "hls" && u.Path == "") {
+			host := u.Host
+			//file and hls outputs write to the same file names in a directory,
+			//hls outputs served over http are told apart by their identifier
+			switch u.Scheme {
+			case "file", "hls":
+				host = u.Scheme + "://" + path.Clean(u.Path)
+			case "httpts":
+				//served on the single stream.ts url of the flow
+				host = "httpts://"
 			}
-			check[u.Host] = 1
+			if _, ok := check[host]; ok {
+				return fmt.Errorf("duplicate url: %s in %s", host, name)
+			}
+			check[host] = 1
 		}
 		check[key] = 1
 	}
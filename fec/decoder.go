@@ -0,0 +1,252 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package fec
+
+import (
+	"time"
+)
+
+const (
+	//media packets kept for recovery and reordering, sequence jumps larger
+	//than half of this restart the decoder
+	historySize = 4096
+	//FEC packets kept waiting for the media packets they protect
+	maxPendingFEC = 4 * (maxL + maxD)
+)
+
+// Status is the forward error correction status of an input
+type Status struct {
+	Input string `json:"input"`
+	// FEC packets received
+	Packets int `json:"fecpackets"`
+	// lost media packets rebuilt from FEC
+	Recovered int `json:"recovered"`
+	// lost media packets FEC couldn't rebuild in time
+	Unrecoverable int `json:"unrecoverable"`
+}
+
+// Packet is a media packet ready to be forwarded
+type Packet struct {
+	Seq       uint16
+	Payload   []byte
+	Recovered bool
+}
+
+type entry struct {
+	seq       uint16
+	have      bool
+	recovered bool
+	arrival   time.Time
+	payload   []byte
+}
+
+type fecPacket struct {
+	header  Header
+	payload []byte
+}
+
+// last returns the sequence number of the last media packet protected by f
+func (f *fecPacket) last() uint16 {
+	return f.header.SNBase + uint16((f.header.NA-1)*f.header.Offset)
+}
+
+// Decoder rebuilds lost media packets from column and row FEC and forwards
+// the media in sequence order, like the 2022-7 merger packets are held at
+// most latency waiting for a gap to be filled. A Decoder is not safe for
+// concurrent use.
+type Decoder struct {
+	latency time.Duration
+	started bool
+	//next sequence number to forward
+	next    uint16
+	newest  uint16
+	pending int
+	history [historySize]entry
+	fecs    []*fecPacket
+	status  Status
+}
+
+func NewDecoder(input string, latency time.Duration) *Decoder {
+	return &Decoder{
+		latency: latency,
+		status:  Status{Input: input},
+	}
+}
+
+func (d *Decoder) entry(seq uint16) *entry {
+	return &d.history[int(seq)%historySize]
+}
+
+// have returns whether media packet seq was received or recovered
+func (d *Decoder) have(seq uint16) bool {
+	e := d.entry(seq)
+	return e.have && e.seq == seq
+}
+
+func (d *Decoder) reset(seq uint16) {
+	d.history = [historySize]entry{}
+	d.fecs = d.fecs[:0]
+	d.pending = 0
+	d.next = seq
+	d.newest = seq
+}
+
+func (d *Decoder) store(seq uint16, payload []byte, now time.Time, recovered bool) {
+	e := d.entry(seq)
+	e.seq = seq
+	e.have = true
+	e.recovered = recovered
+	e.arrival = now
+	e.payload = append(e.payload[:0], payload...)
+	d.pending++
+	if int16(seq-d.newest) > 0 {
+		d.newest = seq
+	}
+}
+
+// Media adds a received media packet
+func (d *Decoder) Media(seq uint16, payload []byte, now time.Time) {
+	if !d.started {
+		d.started = true
+		d.reset(seq)
+	}
+	diff := int16(seq - d.next)
+	if diff >= historySize/2 || diff <= -historySize/2 {
+		d.reset(seq)
+		diff = 0
+	}
+	if diff < 0 || d.have(seq) {
+		//late or already recovered
+		return
+	}
+	d.store(seq, payload, now, false)
+}
+
+// FEC adds a received column or row FEC packet
+func (d *Decoder) FEC(p []byte) error {
+	h, payload, err := ParseHeader(p)
+	if err != nil {
+		return err
+	}
+	d.status.Packets++
+	f := &fecPacket{header: *h, payload: append([]byte(nil), payload...)}
+	if !d.started || int16(f.last()-d.next) < 0 {
+		//protects packets already forwarded
+		return nil
+	}
+	if len(d.fecs) >= maxPendingFEC {
+		d.fecs = append(d.fecs[:0], d.fecs[1:]...)
+	}
+	d.fecs = append(d.fecs, f)
+	return nil
+}
+
+// rebuild recovers the single missing media packet protected by f, it
+// returns false when f is of no use (yet)
+func (d *Decoder) rebuild(f *fecPacket, now time.Time) bool {
+	missing, found := uint16(0), 0
+	for i := 0; i < f.header.NA; i++ {
+		seq := f.header.SNBase + uint16(i*f.header.Offset)
+		if !d.have(seq) {
+			missing = seq
+			found++
+		}
+	}
+	if found != 1 || int16(missing-d.next) < 0 {
+		return false
+	}
+	length := f.header.LengthRecovery
+	payload := append(make([]byte, 0, len(f.payload)), f.payload...)
+	for i := 0; i < f.header.NA; i++ {
+		seq := f.header.SNBase + uint16(i*f.header.Offset)
+		if seq == missing {
+			continue
+		}
+		e := d.entry(seq)
+		length ^= uint16(len(e.payload))
+		if len(e.payload) > len(payload) {
+			return false
+		}
+		for j, b := range e.payload {
+			payload[j] ^= b
+		}
+	}
+	if int(length) > len(payload) || length == 0 {
+		return false
+	}
+	d.store(missing, payload[:length], now, true)
+	d.status.Recovered++
+	return true
+}
+
+// recover rebuilds all packets it can, repeating while a rebuilt packet
+// completes another group (2D)
+func (d *Decoder) recover(now time.Time) {
+	for progress := true; progress; {
+		progress = false
+		fecs := d.fecs[:0]
+		for _, f := range d.fecs {
+			if d.rebuild(f, now) {
+				progress = true
+				continue
+			}
+			if int16(f.last()-d.next) >= 0 {
+				fecs = append(fecs, f)
+			}
+		}
+		d.fecs = fecs
+	}
+}
+
+// Ready returns the media packets that can be forwarded in sequence order,
+// rebuilding lost packets when possible. Gaps are skipped once a later
+// packet has waited longer than latency. The payloads are only valid until
+// the next call to Media.
+func (d *Decoder) Ready(now time.Time, out []Packet) []Packet {
+	recovered := false
+	//FEC may complete a group without further media arriving, e.g. when
+	//the last packet sent was lost
+	for d.pending > 0 || (!recovered && len(d.fecs) > 0) {
+		if d.have(d.next) {
+			e := d.entry(d.next)
+			out = append(out, Packet{Seq: d.next, Payload: e.payload, Recovered: e.recovered})
+			d.pending--
+			d.next++
+			continue
+		}
+		if !recovered {
+			recovered = true
+			d.recover(now)
+			continue
+		}
+		if d.pending == 0 {
+			break
+		}
+		//find the first packet after the gap
+		seq := d.next + 1
+		for !d.have(seq) && seq != d.newest {
+			seq++
+		}
+		if now.Sub(d.entry(seq).arrival) < d.latency {
+			break
+		}
+		for d.next != seq {
+			d.status.Unrecoverable++
+			d.next++
+		}
+	}
+	return out
+}
+
+// Pending returns whether packets are held waiting for a gap to be filled
+func (d *Decoder) Pending() bool {
+	return d.pending > 0
+}
+
+func (d *Decoder) Status() Status {
+	return d.status
+}
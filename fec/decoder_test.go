@@ -0,0 +1,99 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package fec
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecoderRecovery(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		start  uint16
+		//positions in the second matrix of the media packets lost, the
+		//first matrix starts the decoder
+		lost          []int
+		unrecoverable int
+	}{
+		{"1d single loss", Params{L: 4, D: 4}, 1000, []int{5}, 0},
+		{"1d one loss per column", Params{L: 4, D: 4}, 1000, []int{0, 5, 10, 15}, 0},
+		{"1d two losses in a column", Params{L: 4, D: 4}, 1000, []int{1, 5}, 2},
+		{"2d one loss per row", Params{L: 4, D: 4, Rows: true}, 1000, []int{4, 8}, 0},
+		{"2d one loss per column", Params{L: 4, D: 4, Rows: true}, 1000, []int{4, 5}, 0},
+		{"2d row after column", Params{L: 4, D: 4, Rows: true}, 1000, []int{0, 1, 4}, 0},
+		{"2d wrapping", Params{L: 5, D: 4, Rows: true}, 65520, []int{3, 8}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.params
+			matrix := p.L * p.D
+			lost := make(map[uint16]bool)
+			for _, i := range tt.lost {
+				lost[tt.start+uint16(matrix+i)] = true
+			}
+			latency := time.Duration(DefaultLatencyMS) * time.Millisecond
+			e := NewEncoder(p)
+			d := NewDecoder("test", latency)
+			now := time.Now()
+			var out []Packet
+			//the payloads returned by Ready are only valid until the next
+			//call to Media
+			collect := func(ready []Packet) {
+				for _, r := range ready {
+					r.Payload = append([]byte(nil), r.Payload...)
+					out = append(out, r)
+				}
+			}
+			for i := 0; i < 2*matrix; i++ {
+				seq := tt.start + uint16(i)
+				payload := mediaPayload(seq)
+				column, row := e.Add(rtpHeader(seq), payload)
+				if !lost[seq] {
+					d.Media(seq, payload, now)
+				}
+				for _, fp := range [][]byte{column, row} {
+					if fp == nil {
+						continue
+					}
+					if err := d.FEC(fp); err != nil {
+						t.Fatal(err)
+					}
+				}
+				collect(d.Ready(now, nil))
+			}
+			collect(d.Ready(now.Add(latency+time.Millisecond), nil))
+			if d.Pending() {
+				t.Fatal("packets still held after the latency expired")
+			}
+
+			want := 2*matrix - tt.unrecoverable
+			if len(out) != want {
+				t.Fatalf("expected %d packets, got %d", want, len(out))
+			}
+			for i := 1; i < len(out); i++ {
+				if int16(out[i].Seq-out[i-1].Seq) <= 0 {
+					t.Fatalf("packets out of order: %d after %d", out[i].Seq, out[i-1].Seq)
+				}
+			}
+			for _, pkt := range out {
+				if pkt.Recovered != lost[pkt.Seq] {
+					t.Fatalf("packet %d: recovered %v, lost %v", pkt.Seq, pkt.Recovered, lost[pkt.Seq])
+				}
+				if !bytes.Equal(pkt.Payload, mediaPayload(pkt.Seq)) {
+					t.Fatalf("packet %d: payload mismatch", pkt.Seq)
+				}
+			}
+			status := d.Status()
+			if status.Recovered != len(tt.lost)-tt.unrecoverable || status.Unrecoverable != tt.unrecoverable {
+				t.Fatalf("expected %d recovered and %d unrecoverable, got %+v", len(tt.lost)-tt.unrecoverable, tt.unrecoverable, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package fec
+
+// Encoder generates the column and row FEC packets of a media stream. An
+// Encoder is not safe for concurrent use.
+type Encoder struct {
+	params  Params
+	columns []accumulator
+	row     accumulator
+	//position of the next media packet in the matrix
+	pos       int
+	columnSeq uint16
+	rowSeq    uint16
+	columnBuf []byte
+	rowBuf    []byte
+}
+
+func NewEncoder(p Params) *Encoder {
+	return &Encoder{
+		params:  p,
+		columns: make([]accumulator, p.L),
+	}
+}
+
+// Add protects a media packet, header is its 12 byte RTP header. It returns
+// the column and row FEC packets completed by it, nil when none is. The
+// returned packets are only valid until the next call.
+func (e *Encoder) Add(header, payload []byte) (column, row []byte) {
+	col := e.pos % e.params.L
+	c := &e.columns[col]
+	c.add(header, payload)
+	if e.params.Rows {
+		e.row.add(header, payload)
+	}
+	e.pos++
+	//column FEC packets go out during the last row of the matrix, spread
+	//between its media packets
+	if c.count == e.params.D {
+		e.columnBuf = e.packet(e.columnBuf, c, e.columnSeq, header, &Header{
+			Offset: e.params.L,
+			NA:     e.params.D,
+		})
+		e.columnSeq++
+		c.count = 0
+		column = e.columnBuf
+	}
+	if e.params.Rows && e.row.count == e.params.L {
+		e.rowBuf = e.packet(e.rowBuf, &e.row, e.rowSeq, header, &Header{
+			Row:    true,
+			Offset: 1,
+			NA:     e.params.L,
+		})
+		e.rowSeq++
+		e.row.count = 0
+		row = e.rowBuf
+	}
+	if e.pos == e.params.L*e.params.D {
+		e.pos = 0
+	}
+	return
+}
+
+// packet builds the FEC packet of a, the RTP timestamp is taken from the
+// media packet completing it
+func (e *Encoder) packet(buf []byte, a *accumulator, seq uint16, media []byte, h *Header) []byte {
+	h.SNBase = a.snBase
+	h.LengthRecovery = a.lengthRecovery
+	h.PTRecovery = a.ptRecovery
+	h.TSRecovery = a.tsRecovery
+	size := RTPHeaderSize + HeaderSize + len(a.payload)
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	buf[0] = 0x80
+	buf[1] = PayloadType
+	buf[2] = byte(seq >> 8)
+	buf[3] = byte(seq)
+	copy(buf[4:8], media[4:8])
+	buf[8], buf[9], buf[10], buf[11] = 0, 0, 0, 0 //SSRC
+	h.marshal(buf[RTPHeaderSize:])
+	copy(buf[RTPHeaderSize+HeaderSize:], a.payload)
+	return buf
+}
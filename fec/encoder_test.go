@@ -0,0 +1,132 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package fec
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+func rtpHeader(seq uint16) []byte {
+	ts := uint32(seq) * 3600
+	return []byte{0x80, 33, byte(seq >> 8), byte(seq), byte(ts >> 24), byte(ts >> 16), byte(ts >> 8), byte(ts), 0, 0, 0, 1}
+}
+
+// mediaPayload returns a payload of a length and content depending on seq
+func mediaPayload(seq uint16) []byte {
+	p := make([]byte, 188*(1+int(seq)%7))
+	for i := range p {
+		p[i] = byte(int(seq) + i)
+	}
+	return p
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    *Params
+		wantErr bool
+	}{
+		{url: "rtp://239.0.0.1:5000"},
+		{url: "rtp://239.0.0.1:5000?fec=1d", want: &Params{L: DefaultL, D: DefaultD, LatencyMS: DefaultLatencyMS}},
+		{url: "rtp://239.0.0.1:5000?fec=2d&fecl=5&fecd=4&feclatency=100", want: &Params{L: 5, D: 4, Rows: true, LatencyMS: 100}},
+		{url: "rtp://239.0.0.1:5000?fec=3d", wantErr: true},
+		{url: "rtp://239.0.0.1:5000?fecl=5", wantErr: true},
+		{url: "rtp://239.0.0.1:5000?fec=1d&fecl=five", wantErr: true},
+		{url: "rtp://239.0.0.1:5000?fec=1d&fecl=21", wantErr: true},
+		{url: "rtp://239.0.0.1:5000?fec=1d&fecd=3", wantErr: true},
+		{url: "rtp://239.0.0.1:5000?fec=1d&fecl=20&fecd=20", wantErr: true},
+		{url: "rtp://239.0.0.1:5000?fec=1d&feclatency=0", wantErr: true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParseParams(u)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error: %v", tt.url, err)
+			continue
+		}
+		switch {
+		case tt.want == nil && p != nil:
+			t.Errorf("%s: expected no params, got %+v", tt.url, *p)
+		case tt.want != nil && (p == nil || *p != *tt.want):
+			t.Errorf("%s: expected %+v, got %+v", tt.url, *tt.want, p)
+		}
+	}
+}
+
+func TestEncoderMatrix(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		start  uint16
+	}{
+		{"1d", Params{L: 4, D: 4}, 1000},
+		{"2d", Params{L: 5, D: 4, Rows: true}, 1000},
+		{"2d wrapping", Params{L: 5, D: 4, Rows: true}, 65530},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.params
+			e := NewEncoder(p)
+			var columns, rows [][]byte
+			for i := 0; i < p.L*p.D; i++ {
+				seq := tt.start + uint16(i)
+				column, row := e.Add(rtpHeader(seq), mediaPayload(seq))
+				if column != nil {
+					columns = append(columns, append([]byte(nil), column...))
+				}
+				if row != nil {
+					rows = append(rows, append([]byte(nil), row...))
+				}
+			}
+			if len(columns) != p.L {
+				t.Fatalf("expected %d column packets, got %d", p.L, len(columns))
+			}
+			wantRows := 0
+			if p.Rows {
+				wantRows = p.D
+			}
+			if len(rows) != wantRows {
+				t.Fatalf("expected %d row packets, got %d", wantRows, len(rows))
+			}
+			check := func(fp []byte, row bool, base uint16, offset, na int) {
+				h, payload, err := ParseHeader(fp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if h.Row != row || h.SNBase != base || h.Offset != offset || h.NA != na {
+					t.Fatalf("unexpected header %+v, expected row %v snbase %d offset %d na %d", *h, row, base, offset, na)
+				}
+				var want []byte
+				length := uint16(0)
+				for i := 0; i < na; i++ {
+					media := mediaPayload(base + uint16(i*offset))
+					length ^= uint16(len(media))
+					for len(want) < len(media) {
+						want = append(want, 0)
+					}
+					for j, b := range media {
+						want[j] ^= b
+					}
+				}
+				if h.LengthRecovery != length || !bytes.Equal(payload, want) {
+					t.Fatalf("FEC packet for snbase %d doesn't XOR the media packets it protects", base)
+				}
+			}
+			for i, c := range columns {
+				check(c, false, tt.start+uint16(i), p.L, p.D)
+			}
+			for i, r := range rows {
+				check(r, true, tt.start+uint16(i*p.L), 1, p.L)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+// Package fec implements SMPTE 2022-1 (Pro-MPEG COP3) column and row XOR
+// forward error correction for RTP carrying MPEG-TS
+package fec
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	DefaultL = 10
+	DefaultD = 10
+	// ms a receiver holds media waiting for FEC to fill a gap
+	DefaultLatencyMS = 200
+	// column FEC is sent on the media port + ColumnPortOffset, row FEC on
+	// the media port + RowPortOffset
+	ColumnPortOffset = 2
+	RowPortOffset    = 4
+
+	RTPHeaderSize = 12
+	HeaderSize    = 16
+	// payload type of the FEC streams
+	PayloadType = 96
+
+	maxL      = 20
+	maxD      = 20
+	minD      = 4
+	maxMatrix = 100
+)
+
+// Params configures the FEC streams of a media stream, the media packets are
+// protected in matrices of L columns by D rows
+type Params struct {
+	L int
+	D int
+	// send row FEC besides column FEC (2D)
+	Rows bool
+	// ms a receiver holds media waiting for FEC to fill a gap
+	LatencyMS int
+}
+
+// ParseParams returns the FEC params of an rtp:// url, nil when FEC is not
+// enabled: rtp://host:port?fec=2d&fecl=10&fecd=10&feclatency=200, fec is 1d
+// for column FEC only or 2d for column and row FEC. Senders use fecl and
+// fecd, receivers take the matrix from the FEC packets and use feclatency.
+func ParseParams(u *url.URL) (*Params, error) {
+	q := u.Query()
+	p := &Params{L: DefaultL, D: DefaultD, LatencyMS: DefaultLatencyMS}
+	switch q.Get("fec") {
+	case "":
+		if q.Get("fecl") != "" || q.Get("fecd") != "" || q.Get("feclatency") != "" {
+			return nil, errors.New("fecl, fecd and feclatency require fec=1d or fec=2d")
+		}
+		return nil, nil
+	case "1d":
+		//
+	case "2d":
+		p.Rows = true
+	default:
+		return nil, fmt.Errorf("fec must be 1d or 2d, got %s", q.Get("fec"))
+	}
+	for param, v := range map[string]*int{"fecl": &p.L, "fecd": &p.D, "feclatency": &p.LatencyMS} {
+		s := q.Get(param)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number: %s", param, s)
+		}
+		*v = n
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the matrix against the limits of SMPTE 2022-1
+func (p *Params) Validate() error {
+	if p.L < 1 || p.L > maxL {
+		return fmt.Errorf("fecl must be between 1 and %d, got %d", maxL, p.L)
+	}
+	if p.D < minD || p.D > maxD {
+		return fmt.Errorf("fecd must be between %d and %d, got %d", minD, maxD, p.D)
+	}
+	if p.L*p.D > maxMatrix {
+		return fmt.Errorf("fecl * fecd must be at most %d, got %d", maxMatrix, p.L*p.D)
+	}
+	if p.LatencyMS < 1 {
+		return fmt.Errorf("feclatency must be positive, got %d", p.LatencyMS)
+	}
+	return nil
+}
+
+// Header is the FEC header following the RTP header of a FEC packet
+type Header struct {
+	SNBase         uint16
+	LengthRecovery uint16
+	PTRecovery     byte
+	TSRecovery     uint32
+	// row FEC when set, column FEC otherwise
+	Row bool
+	// L for column FEC, 1 for row FEC
+	Offset int
+	// D for column FEC, L for row FEC
+	NA int
+}
+
+func (h *Header) marshal(b []byte) {
+	b[0] = byte(h.SNBase >> 8)
+	b[1] = byte(h.SNBase)
+	b[2] = byte(h.LengthRecovery >> 8)
+	b[3] = byte(h.LengthRecovery)
+	b[4] = 0x80 | h.PTRecovery&0x7f //E bit
+	b[5], b[6], b[7] = 0, 0, 0      //mask
+	b[8] = byte(h.TSRecovery >> 24)
+	b[9] = byte(h.TSRecovery >> 16)
+	b[10] = byte(h.TSRecovery >> 8)
+	b[11] = byte(h.TSRecovery)
+	b[12] = 0 //X, D, type XOR, index
+	if h.Row {
+		b[12] |= 0x40
+	}
+	b[13] = byte(h.Offset)
+	b[14] = byte(h.NA)
+	b[15] = 0 //SNBase ext bits
+}
+
+// ParseHeader parses the FEC header of p, a FEC packet including its RTP
+// header, and returns it with the XORed payload
+func ParseHeader(p []byte) (*Header, []byte, error) {
+	if len(p) < RTPHeaderSize+HeaderSize || p[0]>>6 != 2 {
+		return nil, nil, errors.New("not an RTP FEC packet")
+	}
+	rtpHeaderSize := RTPHeaderSize + 4*int(p[0]&0x0f)
+	if len(p) < rtpHeaderSize+HeaderSize {
+		return nil, nil, errors.New("short RTP FEC packet")
+	}
+	b := p[rtpHeaderSize:]
+	if b[12]&0x38 != 0 {
+		return nil, nil, errors.New("unsupported FEC type")
+	}
+	h := &Header{
+		SNBase:         uint16(b[0])<<8 | uint16(b[1]),
+		LengthRecovery: uint16(b[2])<<8 | uint16(b[3]),
+		PTRecovery:     b[4] & 0x7f,
+		TSRecovery:     uint32(b[8])<<24 | uint32(b[9])<<16 | uint32(b[10])<<8 | uint32(b[11]),
+		Row:            b[12]&0x40 != 0,
+		Offset:         int(b[13]),
+		NA:             int(b[14]),
+	}
+	if h.Offset < 1 || h.NA < 1 {
+		return nil, nil, errors.New("invalid FEC offset or NA")
+	}
+	return h, b[HeaderSize:], nil
+}
+
+// accumulator XORs the media packets protected by a single FEC packet
+type accumulator struct {
+	count          int
+	snBase         uint16
+	lengthRecovery uint16
+	ptRecovery     byte
+	tsRecovery     uint32
+	payload        []byte
+}
+
+// add XORs a media packet, its 12 byte RTP header and payload
+func (a *accumulator) add(header, payload []byte) {
+	if a.count == 0 {
+		a.snBase = uint16(header[2])<<8 | uint16(header[3])
+		a.lengthRecovery = 0
+		a.ptRecovery = 0
+		a.tsRecovery = 0
+		a.payload = a.payload[:0]
+	}
+	a.count++
+	a.lengthRecovery ^= uint16(len(payload))
+	a.ptRecovery ^= header[1] & 0x7f
+	a.tsRecovery ^= uint32(header[4])<<24 | uint32(header[5])<<16 | uint32(header[6])<<8 | uint32(header[7])
+	for len(a.payload) < len(payload) {
+		a.payload = append(a.payload, 0)
+	}
+	for i, b := range payload {
+		a.payload[i] ^= b
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/recording"
+)
+
+const (
+	captureIdentifier = "capture"
+	//retention is per segment, so short segments keep the window tight
+	captureSegment = time.Minute
+)
+
+// ErrNoCapture is returned by Clip for flows without a capture
+var ErrNoCapture = errors.New("flow has no capture configured")
+
+// captureOutput returns the file output recording the capture, it is
+// supervised like the configured outputs
+func captureOutput(c *config.Capture) config.Output {
+	window := c.WindowSeconds
+	if window == 0 {
+		window = config.DefaultCaptureWindow
+	}
+	q := url.Values{}
+	q.Set("rotate", captureSegment.String())
+	q.Set("maxage", (time.Duration(window) * time.Second).String())
+	u := url.URL{Scheme: "file", Path: c.Dir, RawQuery: q.Encode()}
+	return config.Output{
+		Identifier: captureIdentifier,
+		Url:        u.String(),
+	}
+}
+
+// setupCapture starts the capture of the flow config, configLock must be
+// held
+func (f *Flow) setupCapture() error {
+	if f.config.Capture == nil {
+		return nil
+	}
+	oc := captureOutput(f.config.Capture)
+	if err := f.setupOutput(&oc); err != nil {
+		return fmt.Errorf("failed to setup capture: %w", err)
+	}
+	return nil
+}
+
+// updateCapture replaces the capture of old by that of c, configLock must be
+// held
+func (f *Flow) updateCapture(old, c *config.Capture) error {
+	if old != nil {
+		oc := captureOutput(old)
+		if oh, ok := f.configuredOutputs[oc.Url]; ok {
+			oh.out.Close()
+			delete(f.configuredOutputs, oc.Url)
+		}
+	}
+	if c == nil {
+		return nil
+	}
+	oc := captureOutput(c)
+	if err := f.setupOutput(&oc); err != nil {
+		return fmt.Errorf("failed to setup capture: %w", err)
+	}
+	return nil
+}
+
+// Clip opens the captured part of the flow between start and end
+func (f *Flow) Clip(start, end time.Time) (*recording.Clip, error) {
+	f.configLock.Lock()
+	capture := f.config.Capture
+	f.configLock.Unlock()
+	if capture == nil {
+		return nil, ErrNoCapture
+	}
+	return recording.OpenClip(capture.Dir, f.identifier, start, end)
+}
@@ -11,16 +11,26 @@ import (
 	"fmt"
 	"sync"
 
-	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/odmedia/streamzeug/config"
-	"github.com/odmedia/streamzeug/input"
-	"github.com/odmedia/streamzeug/input/rist"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/stats"
 )
 
+const defaultLatency = 1000
+
 func CreateFlow(ctx context.Context, c *config.Flow) (*Flow, error) {
+	flow, err := newFlow(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	flow.start()
+	return flow, nil
+}
+
+// newFlow sets up the flow described by c, without starting the flow's
+// background routines
+func newFlow(ctx context.Context, c *config.Flow) (*Flow, error) {
 	var flow Flow
 	var err error
 	flow.rcontext = ctx
@@ -39,43 +49,58 @@ func CreateFlow(ctx context.Context, c *config.Flow) (*Flow, error) {
 	}
 
 	if c.Latency == 0 {
-		logging.Log.Info().Str("identifier", c.Identifier).Msg("setting latency to default of 1000ms")
-		c.Latency = 1000
+		logging.Log.Info().Str("identifier", c.Identifier).Msgf("setting latency to default of %dms", defaultLatency)
 	}
 
-	flow.receiver, err = rist.SetupReceiver(flow.context, c.Identifier, c.RistProfile, c.Latency, flow.statsConfig)
+	flow.primary, err = setupSource(flow.context, c.Identifier, &c.Source, flow.statsConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to setup rist receiver %w", err)
+		flow.cancel()
+		return nil, err
 	}
-	flow.configuredInputs = make(map[string]input.Input)
-	for _, i := range c.Inputs {
-		err = flow.setupInput(&i)
+
+	var (
+		backup       mainloop.Source
+		backupConfig mainloop.BackupConfig
+	)
+	if c.Backup != nil {
+		logging.Log.Info().Str("identifier", c.Identifier).Msg("setting up backup source")
+		flow.backup, err = setupSource(flow.context, c.Identifier, &c.Backup.Source, flow.statsConfig)
 		if err != nil {
-			return nil, fmt.Errorf("failed to setup input %s: %w", i, err)
+			flow.primary.destroy()
+			flow.cancel()
+			return nil, fmt.Errorf("failed to setup backup: %w", err)
+		}
+		backup = flow.backup.data
+		backupConfig = mainloop.BackupConfig{
+			Mode:           c.Backup.Mode,
+			SkewMS:         c.Backup.SkewMS,
+			SilenceMS:      c.Backup.SilenceMS,
+			HoldOffMS:      c.Backup.HoldOffMS,
+			MinimalBitrate: c.MinimalBitrate,
 		}
-	}
-	destinationPort := uint16(0)
-	if c.RistProfile != libristwrapper.RistProfileSimple {
-		destinationPort = uint16(c.StreamID)
-	}
-	err = flow.receiver.Start()
-	if err != nil {
-		return nil, fmt.Errorf("failed to start rist receiver %w", err)
-	}
-	rf, err := flow.receiver.ConfigureFlow(destinationPort)
-	if err != nil {
-		return nil, fmt.Errorf("failed to configure rist flow %w", err)
 	}
 
-	m := mainloop.NewMainloop(flow.context, rf, c.Identifier)
+	m := mainloop.NewMainloop(flow.context, flow.primary.data, backup, backupConfig, c.Identifier)
 	flow.m = m
 
 	flow.configuredOutputs = make(map[string]outhandle)
 	for _, o := range c.Outputs {
 		err := flow.setupOutput(&o)
 		if err != nil {
-			return nil, fmt.Errorf("failed to setup output %s: %w", o, err)
+			flow.Stop()
+			return nil, fmt.Errorf("failed to setup output %s: %w", o.Url, err)
 		}
 	}
+	if err := flow.setupCapture(); err != nil {
+		flow.Stop()
+		return nil, err
+	}
 	return &flow, nil
 }
+
+// start runs the background routines of the flow, these stop when the
+// context the flow was started with is cancelled
+func (f *Flow) start() {
+	go f.statsLoop(f.context)
+	go f.supervise(f.context)
+}
@@ -8,12 +8,11 @@ package flow
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
-	"code.videolan.org/rist/ristgo"
 	"github.com/odmedia/streamzeug/config"
-	"github.com/odmedia/streamzeug/input"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/stats"
@@ -23,11 +22,11 @@ type Flow struct {
 	rcontext          context.Context
 	context           context.Context
 	cancel            context.CancelFunc
-	receiver          ristgo.Receiver
+	primary           *source
+	backup            *source
 	configuredOutputs map[string]outhandle
 	configLock        sync.Mutex
 	config            config.Flow
-	configuredInputs  map[string]input.Input
 	m                 *mainloop.Mainloop
 	outputWait        *sync.WaitGroup
 	statsConfig       *stats.Stats
@@ -35,18 +34,66 @@ type Flow struct {
 }
 
 func (f *Flow) Status() *mainloop.Status {
-	mlStatus := f.m.Status()
+	return f.status(f.m.Status())
+}
+
+// Snapshot returns the status like Status without affecting the bitrate
+// window of the next Status call
+func (f *Flow) Snapshot() *mainloop.Status {
+	return f.status(f.m.Snapshot())
+}
+
+func (f *Flow) status(mlStatus *mainloop.Status) *mainloop.Status {
 	f.configLock.Lock()
 	defer f.configLock.Unlock()
+	mlStatus.Outputs = f.outputStatus(mlStatus.Outputs)
+	mlStatus.FEC = f.primary.fecStatus(mlStatus.FEC)
+	if f.backup != nil {
+		mlStatus.FEC = f.backup.fecStatus(mlStatus.FEC)
+	}
 	if f.config.MinimalBitrate > 0 && f.config.MaxPacketTimeMS > 0 {
 		if mlStatus.Bitrate < f.config.MinimalBitrate || mlStatus.MsSinceLastPacket > f.config.MaxPacketTimeMS {
 			mlStatus.Status = "NOT-OK"
 			mlStatus.OK = false
 		}
 	}
+	if f.config.TR101290 != nil && tr101290NotOK(f.config.TR101290, mlStatus.TR101290) {
+		mlStatus.Status = "NOT-OK"
+		mlStatus.OK = false
+	}
 	return mlStatus
 }
 
+// backupMode returns the backup mode of the flow, empty without a backup
+func (f *Flow) backupMode() string {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	if f.config.Backup == nil {
+		return ""
+	}
+	if f.config.Backup.Mode == "" {
+		return mainloop.BackupModeFailover
+	}
+	return f.config.Backup.Mode
+}
+
+// ForceSource overrides the automatic failover, source must be one of
+// mainloop.SourcePrimary, mainloop.SourceBackup or mainloop.SourceAuto.
+func (f *Flow) ForceSource(source string) error {
+	switch f.backupMode() {
+	case "":
+		return errors.New("flow has no backup source")
+	case mainloop.BackupModeMerge:
+		return errors.New("flow merges its sources, forcing a source is not supported")
+	}
+	switch source {
+	case mainloop.SourcePrimary, mainloop.SourceBackup, mainloop.SourceAuto:
+		f.m.ForceSource(source)
+		return nil
+	}
+	return errors.New("source must be primary, backup or auto")
+}
+
 func (f *Flow) Stop() {
 	f.cancel()
 	for _, o := range f.configuredOutputs {
@@ -58,7 +105,10 @@ func (f *Flow) Wait(timeout time.Duration) {
 	f.m.Wait(timeout)
 	c := make(chan bool)
 	go func() {
-		f.receiver.Destroy()
+		f.primary.destroy()
+		if f.backup != nil {
+			f.backup.destroy()
+		}
 		f.outputWait.Wait()
 		c <- true
 	}()
@@ -7,21 +7,183 @@
 package flow
 
 import (
+	"context"
+	"fmt"
 	"net/url"
+	"reflect"
+	"sort"
+	"sync"
 
+	"code.videolan.org/rist/ristgo"
+	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/fec"
+	"github.com/odmedia/streamzeug/input"
 	"github.com/odmedia/streamzeug/input/rist"
+	"github.com/odmedia/streamzeug/input/srt"
+	"github.com/odmedia/streamzeug/input/udp"
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/stats"
 )
 
-func (f *Flow) setupInput(c *config.Input) error {
+// source is the input side of a flow, either a librist receiver with its
+// peers or an input.Receiver fed by an SRT or UDP input.
+type source struct {
+	context          context.Context
+	cancel           context.CancelFunc
+	identifier       string
+	config           config.Source
+	receiver         ristgo.Receiver
+	inputReceiver    *input.Receiver
+	configuredInputs map[string]input.Input
+	data             mainloop.Source
+	statsConfig      *stats.Stats
+	destroyOnce      sync.Once
+}
+
+func setupSource(ctx context.Context, identifier string, c *config.Source, s *stats.Stats) (*source, error) {
+	var err error
+	src := &source{
+		identifier:       identifier,
+		config:           *c,
+		configuredInputs: make(map[string]input.Input),
+		statsConfig:      s,
+	}
+	src.context, src.cancel = context.WithCancel(ctx)
+	switch c.InputType {
+	case "SRT", "UDP":
+		src.inputReceiver = input.NewReceiver(src.context)
+		for _, i := range c.Inputs {
+			err = src.setupInput(&i)
+			if err != nil {
+				src.destroy()
+				return nil, fmt.Errorf("failed to setup input %s: %w", i, err)
+			}
+		}
+		src.data = src.inputReceiver
+	default:
+		src.data, err = src.setupRistReceiver()
+		if err != nil {
+			src.destroy()
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+func (s *source) setupRistReceiver() (mainloop.Source, error) {
+	var err error
+	latency := s.config.Latency
+	if latency == 0 {
+		latency = defaultLatency
+	}
+	s.receiver, err = rist.SetupReceiver(s.context, s.identifier, s.config.RistProfile, latency, s.statsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup rist receiver %w", err)
+	}
+	for _, i := range s.config.Inputs {
+		err = s.setupInput(&i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup input %s: %w", i, err)
+		}
+	}
+	destinationPort := uint16(0)
+	if s.config.RistProfile != libristwrapper.RistProfileSimple {
+		destinationPort = uint16(s.config.StreamID)
+	}
+	err = s.receiver.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start rist receiver %w", err)
+	}
+	rf, err := s.receiver.ConfigureFlow(destinationPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure rist flow %w", err)
+	}
+	return rist.NewSource(s.context, rf), nil
+}
+
+func (s *source) setupInput(c *config.Input) error {
+	var in input.Input
 	u, err := url.Parse(c.Url)
 	if err != nil {
 		return err
 	}
-	input, err := rist.SetupRistInput(u, f.config.Identifier, f.receiver)
+	switch s.config.InputType {
+	case "SRT":
+		latency := s.config.Latency
+		if latency == 0 {
+			latency = defaultLatency
+		}
+		in, err = srt.SetupSrtInput(s.context, u, s.identifier, latency, s.inputReceiver, s.statsConfig)
+	case "UDP":
+		in, err = udp.SetupUdpInput(s.context, u, s.identifier, s.inputReceiver, s.statsConfig)
+	default:
+		in, err = rist.SetupRistInput(u, s.identifier, s.receiver)
+	}
 	if err != nil {
 		return err
 	}
-	f.configuredInputs[c.Url] = input
+	s.configuredInputs[c.Url] = in
+	return nil
+}
+
+// updateInputs adds and removes inputs so they match inputs
+func (s *source) updateInputs(inputs []config.Input) error {
+	if reflect.DeepEqual(inputs, s.config.Inputs) {
+		return nil
+	}
+	added, removed := diffInputs(s.config.Inputs, inputs)
+	for _, ic := range removed {
+		if i, ok := s.configuredInputs[ic.Url]; ok {
+			i.Close()
+			delete(s.configuredInputs, ic.Url)
+		}
+	}
+	for _, ic := range added {
+		if _, ok := s.configuredInputs[ic.Url]; ok {
+			continue
+		}
+		if err := s.setupInput(&ic); err != nil {
+			return err
+		}
+	}
+	s.config.Inputs = inputs
 	return nil
 }
+
+// fecStatus appends the status of the inputs receiving FEC to status
+func (s *source) fecStatus(status []fec.Status) []fec.Status {
+	start := len(status)
+	for _, i := range s.configuredInputs {
+		if r, ok := i.(input.FECReporter); ok {
+			if st, ok := r.FECStatus(); ok {
+				status = append(status, st)
+			}
+		}
+	}
+	added := status[start:]
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].Input < added[j].Input
+	})
+	return status
+}
+
+// destroy tears down the source, it may be called more than once
+func (s *source) destroy() {
+	s.destroyOnce.Do(func() {
+		s.cancel()
+		if s.receiver != nil {
+			s.receiver.Destroy()
+			return
+		}
+		for _, i := range s.configuredInputs {
+			i.Close()
+		}
+	})
+}
+
+// destroyed returns whether the source was torn down, i.e. it is left in
+// place after rebuilding it and restoring it both failed
+func (s *source) destroyed() bool {
+	return s.context.Err() != nil
+}
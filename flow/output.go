@@ -8,18 +8,27 @@ package flow
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/output"
 	"github.com/odmedia/streamzeug/output/dektecasi"
+	"github.com/odmedia/streamzeug/output/file"
+	"github.com/odmedia/streamzeug/output/hls"
+	"github.com/odmedia/streamzeug/output/httpts"
+	"github.com/odmedia/streamzeug/output/rist"
 	"github.com/odmedia/streamzeug/output/srt"
+	"github.com/odmedia/streamzeug/output/tcp"
 	"github.com/odmedia/streamzeug/output/udp"
 )
 
 type outhandle struct {
-	out  output.Output
-	conf config.Output
+	out     output.Output
+	conf    config.Output
+	restart *restartState
 }
 
 func (f *Flow) setupOutput(c *config.Output) (err error) {
@@ -31,19 +40,115 @@ func (f *Flow) setupOutput(c *config.Output) (err error) {
 	if err != nil {
 		return fmt.Errorf("couldn't parse output url %s: %w", c.Url, err)
 	}
+	opts := mainloop.OutputOptions{
+		Key:        c.Url,
+		Identifier: c.Identifier,
+		QueueDepth: c.QueueDepth,
+		Delay:      time.Duration(c.DelayMS) * time.Millisecond,
+		SpoolDir:   c.SpoolDir,
+	}
 	switch outputurl.Scheme {
 	case "udp", "rtp":
-		out, err = udp.ParseUdpOutput(f.context, outputurl, f.identifier, f.m)
+		out, err = udp.ParseUdpOutput(f.context, outputurl, f.identifier, f.m, opts)
 	case "srt":
-		out, err = srt.ParseSrtOutput(f.context, outputurl, f.identifier, c.Identifier, f.m, f.statsConfig, f.outputWait)
+		out, err = srt.ParseSrtOutput(f.context, outputurl, f.identifier, c.Identifier, f.m, opts, f.statsConfig, f.outputWait)
+	case "rist":
+		peers := make([]*url.URL, 0, len(c.Peers))
+		for _, p := range c.Peers {
+			peerurl, err := url.Parse(p)
+			if err != nil {
+				return fmt.Errorf("couldn't parse rist peer url %s: %w", p, err)
+			}
+			peers = append(peers, peerurl)
+		}
+		out, err = rist.ParseRistOutput(f.context, outputurl, peers, f.identifier, c.Identifier, f.m, opts, f.statsConfig)
 	case "dektecasi":
-		out, err = dektecasi.ParseURL(f.context, outputurl, f.identifier, c.Identifier, f.m, f.statsConfig)
+		out, err = dektecasi.ParseURL(f.context, outputurl, f.identifier, c.Identifier, f.m, opts, f.statsConfig)
+	case "file":
+		out, err = file.ParseFileOutput(f.context, outputurl, f.identifier, f.m, opts)
+	case "hls":
+		out, err = hls.ParseHlsOutput(f.context, outputurl, f.identifier, f.m, opts)
+	case "tcp":
+		out, err = tcp.ParseTcpOutput(f.context, outputurl, f.identifier, c.Identifier, f.m, opts, f.statsConfig, f.outputWait)
+	case "httpts":
+		out, err = httpts.ParseHttpTsOutput(f.context, outputurl, f.identifier, c.Identifier, f.m, opts, f.statsConfig)
 	default:
 		return fmt.Errorf("output url scheme: %s not implemented", outputurl.Scheme)
 	}
 	if err != nil {
 		return fmt.Errorf("couldn't setup %s output: %s: %w", outputurl.Scheme, outputurl, err)
 	}
-	f.configuredOutputs[c.Url] = outhandle{out: out, conf: *c}
+	f.configuredOutputs[c.Url] = outhandle{out: out, conf: *c, restart: &restartState{}}
 	return nil
 }
+
+// outputStatus lists the configured outputs with their state, combined with
+// the counters of the mainloop queues feeding them, configLock must be held
+func (f *Flow) outputStatus(queues []mainloop.OutputStatus) []mainloop.OutputStatus {
+	byKey := make(map[string][]mainloop.OutputStatus)
+	for _, q := range queues {
+		byKey[q.Key] = append(byKey[q.Key], q)
+	}
+	outputs := f.config.Outputs
+	if f.config.Capture != nil {
+		outputs = append(append([]config.Output(nil), outputs...), captureOutput(f.config.Capture))
+	}
+	status := make([]mainloop.OutputStatus, 0, len(outputs))
+	for _, c := range outputs {
+		oh, ok := f.configuredOutputs[c.Url]
+		if !ok {
+			continue
+		}
+		s := mainloop.OutputStatus{
+			Key:        c.Url,
+			Identifier: c.Identifier,
+			Name:       oh.out.String(),
+			Count:      oh.out.Count(),
+			QueueDepth: c.QueueDepth,
+		}
+		for i := range byKey[c.Url] {
+			s.Accumulate(&byKey[c.Url][i])
+		}
+		oh.restart.status(&s)
+		if r, ok := oh.out.(output.StatusReporter); ok {
+			s.Status = r.Status()
+		} else if len(byKey[c.Url]) > 0 {
+			s.State = output.StateActive
+		} else {
+			s.State = output.StateFailed
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// outputHandler returns the http.Handler of the first output matching
+func (f *Flow) outputHandler(match func(oh outhandle) bool) (http.Handler, bool) {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	for _, oh := range f.configuredOutputs {
+		if !match(oh) {
+			continue
+		}
+		if h, ok := oh.out.(http.Handler); ok {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+// OutputHandler returns the http.Handler of the output with identifier, for
+// outputs served over http such as hls
+func (f *Flow) OutputHandler(identifier string) (http.Handler, bool) {
+	return f.outputHandler(func(oh outhandle) bool {
+		return oh.conf.Identifier == identifier
+	})
+}
+
+// StreamHandler returns the http.Handler of the httpts output of the flow
+func (f *Flow) StreamHandler() (http.Handler, bool) {
+	return f.outputHandler(func(oh outhandle) bool {
+		u, err := url.Parse(oh.conf.Url)
+		return err == nil && u.Scheme == "httpts"
+	})
+}
@@ -0,0 +1,254 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package flow
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/odmedia/streamzeug/config"
+)
+
+// Actions in a Plan
+const (
+	PlanCreate    = "create"
+	PlanDelete    = "delete"
+	PlanRecreate  = "recreate"
+	PlanUpdate    = "update"
+	PlanUnchanged = "unchanged"
+)
+
+// Plan describes what applying a new config does to a flow
+type Plan struct {
+	Identifier string `json:"identifier"`
+	Action     string `json:"action"`
+	// why the flow is re-created or which settings are updated in place
+	Reasons          []string `json:"reasons,omitempty"`
+	InputsAdded      []string `json:"inputsadded,omitempty"`
+	InputsRemoved    []string `json:"inputsremoved,omitempty"`
+	OutputsAdded     []string `json:"outputsadded,omitempty"`
+	OutputsRemoved   []string `json:"outputsremoved,omitempty"`
+	OutputsRestarted []string `json:"outputsrestarted,omitempty"`
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "flow %s: %s", p.Identifier, p.Action)
+	if len(p.Reasons) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(p.Reasons, ", "))
+	}
+	b.WriteString("\n")
+	list := func(prefix string, items []string) {
+		for _, i := range items {
+			fmt.Fprintf(&b, "  %s %s\n", prefix, i)
+		}
+	}
+	list("+ input", p.InputsAdded)
+	list("- input", p.InputsRemoved)
+	list("+ output", p.OutputsAdded)
+	list("- output", p.OutputsRemoved)
+	list("~ output", p.OutputsRestarted)
+	return b.String()
+}
+
+// recreateReasons returns the changed settings that can't be applied to a
+// running flow, requiring it to be re-created
+func recreateReasons(old, c *config.Flow) []string {
+	var reasons []string
+	switch {
+	case old.Backup == nil && c.Backup != nil:
+		reasons = append(reasons, "backup added")
+	case old.Backup != nil && c.Backup == nil:
+		reasons = append(reasons, "backup removed")
+	case old.Backup != nil:
+		if c.Backup.Mode != old.Backup.Mode || c.Backup.SilenceMS != old.Backup.SilenceMS ||
+			c.Backup.HoldOffMS != old.Backup.HoldOffMS || c.Backup.SkewMS != old.Backup.SkewMS {
+			reasons = append(reasons, "backup settings changed")
+		}
+	}
+	//the stats of the flow are shared by all of its inputs and outputs
+	if c.StatsStdOut != old.StatsStdOut || c.StatsFile != old.StatsFile {
+		reasons = append(reasons, "stats settings changed")
+	}
+	return reasons
+}
+
+// rebuildReasons returns the changed settings of a source that require its
+// receiver to be rebuilt, the mainloop and outputs keep running
+func rebuildReasons(old, c *config.Source) []string {
+	var reasons []string
+	if c.InputType != old.InputType {
+		reasons = append(reasons, "type changed")
+	}
+	if c.Latency != old.Latency {
+		reasons = append(reasons, "latency changed")
+	}
+	if c.RistProfile != old.RistProfile {
+		reasons = append(reasons, "ristprofile changed")
+	}
+	if c.StreamID != old.StreamID {
+		reasons = append(reasons, "streamid changed")
+	}
+	return reasons
+}
+
+// diffInputs returns the inputs to set up and to close, by url
+func diffInputs(old, inputs []config.Input) (added, removed []config.Input) {
+	keep := make(map[string]int)
+	for _, ic := range inputs {
+		keep[ic.Url] = 1
+	}
+	existing := make(map[string]int)
+	for _, ic := range old {
+		existing[ic.Url] = 1
+		if _, ok := keep[ic.Url]; !ok {
+			removed = append(removed, ic)
+		}
+	}
+	for _, ic := range inputs {
+		if _, ok := existing[ic.Url]; !ok {
+			added = append(added, ic)
+		}
+	}
+	return added, removed
+}
+
+// diffOutputs returns the outputs to set up, to close and to re-create
+// because their settings changed, by url
+func diffOutputs(old, outputs []config.Output) (added, removed, restarted []config.Output) {
+	keep := make(map[string]int)
+	for _, oc := range outputs {
+		keep[oc.Url] = 1
+	}
+	existing := make(map[string]config.Output)
+	for _, oc := range old {
+		existing[oc.Url] = oc
+		if _, ok := keep[oc.Url]; !ok {
+			removed = append(removed, oc)
+		}
+	}
+	for _, oc := range outputs {
+		current, ok := existing[oc.Url]
+		switch {
+		case !ok:
+			added = append(added, oc)
+		case !reflect.DeepEqual(current, oc):
+			restarted = append(restarted, oc)
+		}
+	}
+	return added, removed, restarted
+}
+
+func inputUrls(inputs []config.Input) []string {
+	var urls []string
+	for _, i := range inputs {
+		urls = append(urls, i.Url)
+	}
+	return urls
+}
+
+func outputUrls(outputs []config.Output) []string {
+	var urls []string
+	for _, o := range outputs {
+		urls = append(urls, o.Url)
+	}
+	return urls
+}
+
+// updatedSettings returns the changed settings UpdateConfig applies without
+// touching inputs or outputs
+func updatedSettings(old, c *config.Flow) []string {
+	var settings []string
+	if c.MinimalBitrate != old.MinimalBitrate || c.MaxPacketTimeMS != old.MaxPacketTimeMS {
+		settings = append(settings, "status thresholds changed")
+	}
+	if !reflect.DeepEqual(c.TR101290, old.TR101290) {
+		settings = append(settings, "tr101290 changed")
+	}
+	if !reflect.DeepEqual(c.Capture, old.Capture) {
+		settings = append(settings, "capture changed")
+	}
+	return settings
+}
+
+// PlanNew returns the plan for creating a flow from c
+func PlanNew(c *config.Flow) *Plan {
+	return &Plan{
+		Identifier:   c.Identifier,
+		Action:       PlanCreate,
+		InputsAdded:  inputUrls(c.Inputs),
+		OutputsAdded: outputUrls(c.Outputs),
+	}
+}
+
+// planUpdate returns what UpdateConfig does to apply c to a flow running old
+func planUpdate(old, c *config.Flow) *Plan {
+	p := &Plan{
+		Identifier: c.Identifier,
+		Action:     PlanUnchanged,
+	}
+	if reflect.DeepEqual(*old, *c) {
+		return p
+	}
+	if reasons := recreateReasons(old, c); len(reasons) > 0 {
+		p.Action = PlanRecreate
+		p.Reasons = reasons
+		p.InputsAdded = inputUrls(c.Inputs)
+		p.InputsRemoved = inputUrls(old.Inputs)
+		p.OutputsAdded = outputUrls(c.Outputs)
+		p.OutputsRemoved = outputUrls(old.Outputs)
+		return p
+	}
+	p.Action = PlanUpdate
+	p.Reasons = updatedSettings(old, c)
+	p.planSource(&old.Source, &c.Source, "")
+	if c.Backup != nil {
+		p.planSource(&old.Backup.Source, &c.Backup.Source, "backup ")
+	}
+	oadded, oremoved, orestarted := diffOutputs(old.Outputs, c.Outputs)
+	p.OutputsAdded = outputUrls(oadded)
+	p.OutputsRemoved = outputUrls(oremoved)
+	p.OutputsRestarted = outputUrls(orestarted)
+	return p
+}
+
+// planSource adds the changes UpdateConfig makes to a source
+func (p *Plan) planSource(old, c *config.Source, prefix string) {
+	if reasons := rebuildReasons(old, c); len(reasons) > 0 {
+		for _, r := range reasons {
+			p.Reasons = append(p.Reasons, prefix+r)
+		}
+		p.Reasons = append(p.Reasons, prefix+"input rebuilt")
+		p.InputsAdded = append(p.InputsAdded, inputUrls(c.Inputs)...)
+		p.InputsRemoved = append(p.InputsRemoved, inputUrls(old.Inputs)...)
+		return
+	}
+	added, removed := diffInputs(old.Inputs, c.Inputs)
+	p.InputsAdded = append(p.InputsAdded, inputUrls(added)...)
+	p.InputsRemoved = append(p.InputsRemoved, inputUrls(removed)...)
+}
+
+// PlanUpdate returns what UpdateConfig(c) would do, without changing the
+// flow
+func (f *Flow) PlanUpdate(c *config.Flow) *Plan {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	return planUpdate(&f.config, c)
+}
+
+// PlanDelete returns the plan for stopping the flow
+func (f *Flow) PlanDelete() *Plan {
+	f.configLock.Lock()
+	defer f.configLock.Unlock()
+	return &Plan{
+		Identifier:     f.identifier,
+		Action:         PlanDelete,
+		InputsRemoved:  inputUrls(f.config.Inputs),
+		OutputsRemoved: outputUrls(f.config.Outputs),
+	}
+}
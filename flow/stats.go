@@ -0,0 +1,39 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package flow
+
+import (
+	"context"
+	"time"
+
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/stats"
+)
+
+// statsLoop periodically reports the mainloop stats of the flow
+func (f *Flow) statsLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(stats.StatsIntervalSeconds) * time.Second):
+			//
+		}
+		f.statsConfig.HandleStats("", "", nil, f.m.TR101290Stats())
+		for _, s := range f.m.PIDStats() {
+			f.statsConfig.HandleStats("", "", nil, s)
+		}
+		switch f.backupMode() {
+		case "":
+			//
+		case mainloop.BackupModeMerge:
+			f.statsConfig.HandleStats("", "", nil, f.m.MergeStats())
+		default:
+			f.statsConfig.HandleStats("", "", nil, f.m.FailoverStats())
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package flow
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/output"
+)
+
+const (
+	superviseInterval = 500 * time.Millisecond
+	restartBackoffMin = 1 * time.Second
+	restartBackoffMax = 60 * time.Second
+)
+
+// restartState tracks the restarts of a failed output, the restart count is
+// reset once the output stays up for restartBackoffMax
+type restartState struct {
+	restarts    int
+	lastRestart time.Time
+	next        time.Time
+	gaveUp      bool
+}
+
+func (r *restartState) status(s *mainloop.OutputStatus) {
+	s.Restarts = r.restarts
+	s.GaveUp = r.gaveUp
+	if !r.next.IsZero() {
+		next := r.next
+		s.NextRestart = &next
+	}
+}
+
+// backoff returns the delay before restart attempt n, doubling from
+// restartBackoffMin up to restartBackoffMax with jitter over the upper half
+func backoff(n int) time.Duration {
+	d := restartBackoffMax
+	if n < 6 {
+		if b := restartBackoffMin << uint(n); b < d {
+			d = b
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// failedoutput stands in for an output that couldn't be re-created, so it
+// keeps being reported and retried
+type failedoutput struct {
+	name  string
+	state *output.StateTracker
+}
+
+func (f *failedoutput) Close() error {
+	return nil
+}
+
+func (f *failedoutput) Write(*block.Block) (int, error) {
+	return 0, errors.New("output failed")
+}
+
+func (f *failedoutput) String() string {
+	return f.name
+}
+
+func (f *failedoutput) Count() int {
+	return 0
+}
+
+func (f *failedoutput) Status() output.Status {
+	return f.state.Status()
+}
+
+// supervise restarts outputs reporting output.StateFailed
+func (f *Flow) supervise(ctx context.Context) {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			//
+		}
+		f.configLock.Lock()
+		if ctx.Err() == nil {
+			f.superviseOutputs(time.Now())
+		}
+		f.configLock.Unlock()
+	}
+}
+
+// superviseOutputs checks all outputs once, configLock must be held
+func (f *Flow) superviseOutputs(now time.Time) {
+	for key, oh := range f.configuredOutputs {
+		r, ok := oh.out.(output.StatusReporter)
+		if !ok {
+			continue
+		}
+		rs := oh.restart
+		if r.Status().State != output.StateFailed {
+			if rs.restarts > 0 && now.Sub(rs.lastRestart) >= restartBackoffMax {
+				rs.restarts = 0
+			}
+			continue
+		}
+		if oh.conf.MaxRestarts > 0 && rs.restarts >= oh.conf.MaxRestarts {
+			if !rs.gaveUp {
+				logging.Log.Error().Str("identifier", f.identifier).Str("output_identifier", oh.conf.Identifier).Int("restarts", rs.restarts).Msgf("output %s failed, giving up", oh.out.String())
+				rs.gaveUp = true
+			}
+			continue
+		}
+		if rs.next.IsZero() {
+			delay := backoff(rs.restarts)
+			rs.next = now.Add(delay)
+			logging.Log.Warn().Str("identifier", f.identifier).Str("output_identifier", oh.conf.Identifier).Int("restarts", rs.restarts).Msgf("output %s failed, restarting in %s", oh.out.String(), delay.Round(time.Millisecond))
+			continue
+		}
+		if now.Before(rs.next) {
+			continue
+		}
+		f.restartOutput(key, oh, now)
+	}
+}
+
+func (f *Flow) restartOutput(key string, oh outhandle, now time.Time) {
+	rs := oh.restart
+	rs.restarts++
+	rs.lastRestart = now
+	rs.next = time.Time{}
+	logging.Log.Info().Str("identifier", f.identifier).Str("output_identifier", oh.conf.Identifier).Int("restarts", rs.restarts).Msgf("restarting output %s", oh.out.String())
+	oh.out.Close()
+	delete(f.configuredOutputs, key)
+	if err := f.setupOutput(&oh.conf); err != nil {
+		logging.Log.Error().Str("identifier", f.identifier).Str("output_identifier", oh.conf.Identifier).Err(err).Msgf("failed to restart output %s", oh.out.String())
+		failed := &failedoutput{
+			name:  oh.out.String(),
+			state: output.NewStateTracker(output.StateFailed),
+		}
+		failed.state.Error(err)
+		f.configuredOutputs[key] = outhandle{out: failed, conf: oh.conf, restart: rs}
+		return
+	}
+	restarted := f.configuredOutputs[key]
+	restarted.restart = rs
+	f.configuredOutputs[key] = restarted
+}
@@ -0,0 +1,32 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package flow
+
+import (
+	"time"
+
+	"github.com/odmedia/streamzeug/config"
+	"github.com/odmedia/streamzeug/tsanalyzer"
+)
+
+const defaultTR101290HoldMS = 5000
+
+// tr101290NotOK returns wether one of the configured errors occurred within
+// the hold time
+func tr101290NotOK(c *config.TR101290, status *tsanalyzer.Status) bool {
+	hold := time.Duration(c.HoldMS) * time.Millisecond
+	if hold == 0 {
+		hold = defaultTR101290HoldMS * time.Millisecond
+	}
+	for _, name := range c.NotOK {
+		e, ok := status.Priority1[name]
+		if ok && e.LastOccurrence != nil && time.Since(*e.LastOccurrence) < hold {
+			return true
+		}
+	}
+	return false
+}
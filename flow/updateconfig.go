@@ -7,11 +7,13 @@
 package flow
 
 import (
+	"fmt"
 	"reflect"
 	"time"
 
 	"github.com/odmedia/streamzeug/config"
 	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop"
 )
 
 func (f *Flow) UpdateConfig(c *config.Flow) (err error) {
@@ -22,7 +24,7 @@ func (f *Flow) UpdateConfig(c *config.Flow) (err error) {
 			f.configLock.Unlock()
 		}
 	}()
-	if reflect.DeepEqual(f.config, *c) {
+	if reflect.DeepEqual(f.config, *c) && !f.sourceDestroyed() {
 		return nil
 	}
 	logging.Log.Info().Str("identifier", f.config.Identifier).Msg("updating flow config")
@@ -34,76 +36,132 @@ func (f *Flow) UpdateConfig(c *config.Flow) (err error) {
 		logging.Log.Error().Str("identifier", f.config.Identifier).Err(err).Msgf("error configuring: %s", err)
 	}()
 
-	if c.Latency != f.config.Latency || c.RistProfile != f.config.RistProfile || c.StreamID != f.config.StreamID {
-		logging.Log.Info().Str("identifier", f.config.Identifier).Msg("rist settings changed, re-creating")
+	if reasons := recreateReasons(&f.config, c); len(reasons) > 0 {
+		logging.Log.Info().Str("identifier", f.config.Identifier).Strs("reasons", reasons).Msg("settings changed, re-creating")
 		f.Stop()
 		f.Wait(5 * time.Millisecond)
-		newflow, err := CreateFlow(f.rcontext, c)
+		newflow, err := newFlow(f.rcontext, c)
 		if err != nil {
 			return err
 		}
 		shouldUnlock = false
 		*f = *newflow
+		f.start()
 		return nil
 	}
 
-	if !reflect.DeepEqual(c.Inputs, f.config.Inputs) {
-		checkDelete := make(map[string]int)
-		for _, ic := range c.Inputs {
-			checkDelete[ic.Url] = 1
-		}
-
-		for url, i := range f.configuredInputs {
-			if _, ok := checkDelete[url]; !ok {
-				i.Close()
-				delete(f.configuredInputs, url)
-			}
-		}
-
-		for _, ic := range c.Inputs {
-			if _, ok := f.configuredInputs[ic.Url]; !ok {
-				err := f.setupInput(&ic)
-				if err != nil {
-					return err
-				}
-			}
+	if err := f.updateSource(&f.config.Source, &c.Source, false); err != nil {
+		return err
+	}
+	f.config.Source = c.Source
+	if c.Backup != nil {
+		if err := f.updateSource(&f.config.Backup.Source, &c.Backup.Source, true); err != nil {
+			return err
 		}
+		f.config.Backup = c.Backup
 	}
-	f.config.Inputs = c.Inputs
 	if reflect.DeepEqual(f.config, *c) {
 		return nil
 	}
 	if !reflect.DeepEqual(c.Outputs, f.config.Outputs) {
-		checkDelete := make(map[string]int)
-		for _, oc := range c.Outputs {
-			checkDelete[oc.Url] = 1
-		}
-
-		for url, oh := range f.configuredOutputs {
-			if _, ok := checkDelete[url]; !ok {
+		added, removed, restarted := diffOutputs(f.config.Outputs, c.Outputs)
+		for _, oc := range removed {
+			if oh, ok := f.configuredOutputs[oc.Url]; ok {
 				oh.out.Close()
-				delete(f.configuredOutputs, url)
+				delete(f.configuredOutputs, oc.Url)
 			}
 		}
-
-		for _, oc := range c.Outputs {
-			if oh, ok := f.configuredOutputs[oc.Url]; !ok {
-				err := f.setupOutput(&oc)
-				if err != nil {
-					return err
-				}
-			} else {
-				if !reflect.DeepEqual(oh.conf, oc) {
-					oh.out.Close()
-					delete(f.configuredOutputs, oc.Url)
-					err := f.setupOutput(&oc)
-					if err != nil {
-						return err
-					}
+		for _, oc := range append(added, restarted...) {
+			if oh, ok := f.configuredOutputs[oc.Url]; ok {
+				//left over from a previous update that failed half way
+				if reflect.DeepEqual(oh.conf, oc) {
+					continue
 				}
+				oh.out.Close()
+				delete(f.configuredOutputs, oc.Url)
 			}
+			if err := f.setupOutput(&oc); err != nil {
+				return err
+			}
+		}
+	}
+	if c.MinimalBitrate != f.config.MinimalBitrate {
+		f.m.SetMinimalBitrate(c.MinimalBitrate)
+	}
+	if !reflect.DeepEqual(c.Capture, f.config.Capture) {
+		if err := f.updateCapture(f.config.Capture, c.Capture); err != nil {
+			return err
 		}
 	}
 	f.config = *c
 	return nil
 }
+
+// updateSource applies c to the primary or backup source, rebuilding its
+// receiver when needed
+func (f *Flow) updateSource(old, c *config.Source, backup bool) error {
+	current := f.primary
+	if backup {
+		current = f.backup
+	}
+	reasons := rebuildReasons(old, c)
+	if current.destroyed() {
+		reasons = append(reasons, "previous rebuild failed")
+	}
+	if len(reasons) > 0 {
+		logging.Log.Info().Str("identifier", f.config.Identifier).Strs("reasons", reasons).Bool("backup", backup).Msg("input settings changed, rebuilding input")
+		return f.rebuildSource(c, backup)
+	}
+	if backup {
+		return f.backup.updateInputs(c.Inputs)
+	}
+	return f.primary.updateInputs(c.Inputs)
+}
+
+// sourceDestroyed returns whether a source was left destroyed by a failed
+// rebuild
+func (f *Flow) sourceDestroyed() bool {
+	return f.primary.destroyed() || (f.backup != nil && f.backup.destroyed())
+}
+
+// sourceData returns the mainloop source of s, nil if s is nil or destroyed
+func sourceData(s *source) mainloop.Source {
+	if s == nil || s.destroyed() {
+		return nil
+	}
+	return s.data
+}
+
+// rebuildSource replaces the primary or backup source by one set up from c
+// behind the running mainloop, so the outputs and their clients stay
+// connected. The old source is torn down first as the new one may listen on
+// the same addresses, on failure the old config is restored. When that fails
+// too the destroyed source is left in place, detached from the mainloop, and
+// rebuilt by the next update.
+func (f *Flow) rebuildSource(c *config.Source, backup bool) error {
+	old := f.primary
+	if backup {
+		old = f.backup
+		f.m.SetSources(sourceData(f.primary), nil)
+	} else {
+		f.m.SetSources(nil, sourceData(f.backup))
+	}
+	old.destroy()
+	src, err := setupSource(f.context, f.identifier, c, f.statsConfig)
+	if err != nil {
+		err = fmt.Errorf("failed to rebuild input: %w", err)
+		var rerr error
+		src, rerr = setupSource(f.context, f.identifier, &old.config, f.statsConfig)
+		if rerr != nil {
+			f.m.SetSources(sourceData(f.primary), sourceData(f.backup))
+			return fmt.Errorf("%s, restoring previous input failed: %w", err, rerr)
+		}
+	}
+	if backup {
+		f.backup = src
+	} else {
+		f.primary = src
+	}
+	f.m.SetSources(sourceData(f.primary), sourceData(f.backup))
+	return err
+}
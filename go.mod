@@ -15,7 +15,7 @@ require (
 	github.com/mattn/go-pointer v0.0.1
 	github.com/rs/zerolog v1.26.1
 	github.com/sam-kamerer/go-runtime-metrics/v2 v2.0.0
-	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f // indirect
+	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
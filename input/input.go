@@ -6,6 +6,57 @@
 
 package input
 
+import (
+	"context"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/fec"
+)
+
 type Input interface {
 	Close()
 }
+
+// FECReporter is implemented by inputs able to recover losses with forward
+// error correction
+type FECReporter interface {
+	FECStatus() (status fec.Status, ok bool)
+}
+
+// Receiver collects the data of inputs that don't come with a librist
+// receiver of their own, and hands it to the mainloop as data blocks.
+type Receiver struct {
+	ctx      context.Context
+	dataChan chan *block.Block
+	seqNo    uint16
+}
+
+func NewReceiver(ctx context.Context) *Receiver {
+	return &Receiver{
+		ctx:      ctx,
+		dataChan: make(chan *block.Block, 1024),
+	}
+}
+
+func (r *Receiver) DataChannel() <-chan *block.Block {
+	return r.dataChan
+}
+
+// Write queues b for the mainloop numbering it in order of arrival, it takes
+// over the reference to b. Write must not be called concurrently.
+func (r *Receiver) Write(b *block.Block) {
+	r.WriteSeqNo(b, r.seqNo)
+	r.seqNo++
+}
+
+// WriteSeqNo queues b for the mainloop with a sequence number set by the
+// input, i.e. the RTP sequence number.
+func (r *Receiver) WriteSeqNo(b *block.Block, seqNo uint16) {
+	b.SeqNo = uint32(seqNo)
+	select {
+	case r.dataChan <- b:
+		//
+	case <-r.ctx.Done():
+		b.Return()
+	}
+}
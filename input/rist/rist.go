@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/odmedia/streamzeug/block"
 	"github.com/odmedia/streamzeug/input"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/stats"
@@ -107,3 +108,49 @@ func (i *ristinput) Close() {
 		logging.Log.Error().Err(err).Msg("error removing rist peer")
 	}
 }
+
+// ristSource hands the data blocks of a librist receiver flow to the
+// mainloop as streamzeug blocks, without copying the data.
+type ristSource struct {
+	rf       ristgo.ReceiverFlow
+	dataChan chan *block.Block
+}
+
+func (s *ristSource) DataChannel() <-chan *block.Block {
+	return s.dataChan
+}
+
+func (s *ristSource) loop(ctx context.Context) {
+	defer close(s.dataChan)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rb, ok := <-s.rf.DataChannel():
+			if !ok {
+				return
+			}
+			b := block.Wrap(rb.Data, rb.Return)
+			b.TimeStamp = rb.TimeStamp
+			b.SeqNo = rb.SeqNo
+			b.Discontinuity = rb.Discontinuity
+			select {
+			case s.dataChan <- b:
+				//
+			case <-ctx.Done():
+				b.Return()
+				return
+			}
+		}
+	}
+}
+
+// NewSource wraps the data channel of rf for consumption by the mainloop
+func NewSource(ctx context.Context, rf ristgo.ReceiverFlow) *ristSource {
+	s := &ristSource{
+		rf:       rf,
+		dataChan: make(chan *block.Block, 16),
+	}
+	go s.loop(ctx)
+	return s
+}
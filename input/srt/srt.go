@@ -0,0 +1,236 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/haivision/srtgo"
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/input"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/stats"
+	"github.com/rs/zerolog"
+)
+
+const (
+	reconnectInterval = 1 * time.Second
+	//max SRT live mode payload size is 1456 bytes
+	readBufferSize = block.PoolBufferSize
+)
+
+func init() {
+	srtgo.InitSRT()
+}
+
+type srtinput struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	identifier   string
+	Url          *url.URL
+	SanitisedURL *url.URL
+	host         string
+	port         uint16
+	options      map[string]string
+	r            *input.Receiver
+	stats        *stats.Stats
+	logger       zerolog.Logger
+	socketLock   sync.Mutex
+	listener     *srtgo.SrtSocket
+	socket       *srtgo.SrtSocket
+}
+
+func (s *srtinput) Close() {
+	s.cancel()
+	s.socketLock.Lock()
+	defer s.socketLock.Unlock()
+	if s.socket != nil {
+		s.socket.Close()
+		s.socket = nil
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *srtinput) setSocket(socket *srtgo.SrtSocket) bool {
+	s.socketLock.Lock()
+	defer s.socketLock.Unlock()
+	select {
+	case <-s.ctx.Done():
+		return false
+	default:
+		//
+	}
+	s.socket = socket
+	return true
+}
+
+func (s *srtinput) closeSocket(socket *srtgo.SrtSocket) {
+	s.socketLock.Lock()
+	defer s.socketLock.Unlock()
+	if s.socket == socket {
+		s.socket.Close()
+		s.socket = nil
+	}
+}
+
+func (s *srtinput) sleep() bool {
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-time.After(reconnectInterval):
+		return true
+	}
+}
+
+func (s *srtinput) connect() (*srtgo.SrtSocket, string, error) {
+	if s.listener != nil {
+		socket, addr, err := s.listener.Accept()
+		if err != nil {
+			return nil, "", err
+		}
+		return socket, addr.IP.String(), nil
+	}
+	socket := srtgo.NewSrtSocket(s.host, s.port, s.options)
+	if socket == nil {
+		return nil, "", errors.New("got nil srtSocket")
+	}
+	if err := socket.Connect(); err != nil {
+		socket.Close()
+		return nil, "", err
+	}
+	return socket, s.host, nil
+}
+
+func (s *srtinput) receiveLoop() {
+	for {
+		socket, host, err := s.connect()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				//
+			}
+			s.logger.Error().Err(err).Msg("failed to connect srt input")
+			if !s.sleep() {
+				return
+			}
+			continue
+		}
+		if !s.setSocket(socket) {
+			socket.Close()
+			return
+		}
+		s.logger.Info().Str("client", host).Msgf("SRT input connected: %s", host)
+		go s.statsLoop(socket, host)
+		for {
+			b := block.Get(readBufferSize)
+			n, err := socket.Read(b.Data)
+			if err != nil {
+				b.Return()
+				s.logger.Info().Err(err).Str("client", host).Msgf("SRT input disconnected: %s", host)
+				break
+			}
+			if n == 0 {
+				b.Return()
+				continue
+			}
+			b.Data = b.Data[:n]
+			s.r.Write(b)
+		}
+		s.closeSocket(socket)
+		if s.listener == nil && !s.sleep() {
+			return
+		}
+	}
+}
+
+func (s *srtinput) statsLoop(socket *srtgo.SrtSocket, host string) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(time.Duration(stats.StatsIntervalSeconds) * time.Second):
+			//
+		}
+		stats, err := socket.Stats()
+		if err != nil {
+			if errors.Is(err, srtgo.SRTErrno(srtgo.ENoConn)) || errors.Is(err, srtgo.SRTErrno(srtgo.EInvSock)) {
+				return
+			}
+			s.logger.Error().Err(err).Msg("error in srt statsloop")
+			return
+		}
+		go s.stats.HandleStats(host, "", s.Url, stats)
+	}
+}
+
+// SetupSrtInput creates an SRT input, that either calls the host in u, or
+// when host is empty or 0.0.0.0 listens for a single client at a time.
+// latency is used when u doesn't carry a latency option itself.
+func SetupSrtInput(ctx context.Context, u *url.URL, identifier string, latency int, r *input.Receiver, s *stats.Stats) (input.Input, error) {
+	var in srtinput
+	in.Url = u
+	in.SanitisedURL = u
+	if u.Query().Get("passphrase") != "" {
+		sanitised, _ := url.Parse(u.String())
+		q := sanitised.Query()
+		q.Set("passphrase", "REDACTED")
+		sanitised.RawQuery = q.Encode()
+		in.SanitisedURL = sanitised
+	}
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up SRT input: %s", in.SanitisedURL)
+	in.identifier = identifier
+	in.r = r
+	in.stats = s
+	in.logger = logging.Log.With().Str("module", "srt-input").Str("identifier", identifier).Str("srt-url", in.SanitisedURL.String()).Logger()
+	in.host = u.Hostname()
+	if in.host == "" {
+		in.host = "0.0.0.0"
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, err
+	}
+	in.port = uint16(port)
+	in.options = make(map[string]string)
+	for key := range u.Query() {
+		in.options[key] = u.Query().Get(key)
+	}
+	delete(in.options, "identifier")
+	in.options["blocking"] = "0"
+	in.options["transtype"] = "live"
+	if _, ok := in.options["latency"]; !ok && latency > 0 {
+		in.options["latency"] = strconv.Itoa(latency)
+	}
+	if in.host == "0.0.0.0" {
+		in.options["mode"] = "listener"
+	}
+	in.ctx, in.cancel = context.WithCancel(ctx)
+	if in.options["mode"] == "listener" {
+		listener := srtgo.NewSrtSocket(in.host, in.port, in.options)
+		if listener == nil {
+			in.cancel()
+			return nil, errors.New("got nil srtSocket")
+		}
+		if err := listener.Listen(1); err != nil {
+			listener.Close()
+			in.cancel()
+			return nil, err
+		}
+		in.listener = listener
+	}
+	go in.receiveLoop()
+	return &in, nil
+}
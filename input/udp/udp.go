@@ -0,0 +1,437 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/fec"
+	"github.com/odmedia/streamzeug/input"
+	"github.com/odmedia/streamzeug/input/udp/udpstats"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/stats"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	readBufferSize   = 65536
+	socketBufferSize = 4 * 1024 * 1024
+	rtpHeaderSize    = 12
+	//sequence jumps larger than this are treated as a restart of the RTP stream
+	maxSeqJump = 3000
+	//interval held packets are checked for their latency to expire
+	fecFlushInterval = 5 * time.Millisecond
+	//pause after a read error before reading again
+	readErrorBackoff = 50 * time.Millisecond
+)
+
+type udpinput struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	identifier string
+	name       string
+	u          *url.URL
+	c          *net.UDPConn
+	isRtp      bool
+	r          *input.Receiver
+	stats      *stats.Stats
+	logger     zerolog.Logger
+	statsLock  sync.Mutex
+	status     udpstats.UdpInputStats
+	haveSeq    bool
+	expectSeq  uint16
+	//column and row FEC sockets, on port+2 and port+4
+	fecConns []*net.UDPConn
+	//fecLock serializes the decoder and forwarding of the media
+	fecLock  sync.Mutex
+	fec      *fec.Decoder
+	fecReady []fec.Packet
+	//copy of the decoder status, guarded by statsLock so status readers
+	//never wait on fecLock while it forwards to a backed up mainloop
+	fecStatus   fec.Status
+	fecReported fec.Status
+}
+
+func (u *udpinput) Close() {
+	u.cancel()
+	u.c.Close()
+	for _, c := range u.fecConns {
+		c.Close()
+	}
+}
+
+// FECStatus returns the FEC counters since the input was set up, ok is false
+// when the input doesn't receive FEC
+func (u *udpinput) FECStatus() (status fec.Status, ok bool) {
+	if u.fec == nil {
+		return status, false
+	}
+	u.statsLock.Lock()
+	defer u.statsLock.Unlock()
+	return u.fecStatus, true
+}
+
+// parseRTP returns the payload and sequence number of an RTP packet
+func parseRTP(b []byte) ([]byte, uint16, error) {
+	if len(b) < rtpHeaderSize {
+		return nil, 0, errors.New("packet too short for RTP")
+	}
+	if b[0]>>6 != 2 {
+		return nil, 0, fmt.Errorf("unsupported RTP version %d", b[0]>>6)
+	}
+	seq := binary.BigEndian.Uint16(b[2:4])
+	offset := rtpHeaderSize + 4*int(b[0]&0x0f)
+	end := len(b)
+	if b[0]&0x10 != 0 {
+		if offset+4 > end {
+			return nil, 0, errors.New("RTP extension header truncated")
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(b[offset+2:offset+4]))
+	}
+	if b[0]&0x20 != 0 && end > 0 {
+		end -= int(b[end-1])
+	}
+	if offset > end {
+		return nil, 0, errors.New("RTP header exceeds packet")
+	}
+	return b[offset:end], seq, nil
+}
+
+// trackSeq updates the loss and reorder counters with seq, statsLock must be
+// held
+func (u *udpinput) trackSeq(seq uint16) {
+	if !u.haveSeq {
+		u.haveSeq = true
+		u.expectSeq = seq + 1
+		return
+	}
+	diff := int16(seq - u.expectSeq)
+	switch {
+	case diff == 0:
+		u.expectSeq++
+	case diff > 0 && diff < maxSeqJump:
+		u.status.RtpLost += int(diff)
+		u.expectSeq = seq + 1
+	case diff < 0 && diff > -maxSeqJump:
+		u.status.RtpReordered++
+	default:
+		u.logger.Warn().Msgf("RTP sequence jumped from %d to %d, resyncing", u.expectSeq-1, seq)
+		u.expectSeq = seq + 1
+	}
+}
+
+// readFailed logs a read error and backs off, so a socket that keeps failing
+// doesn't spin. It returns false once the input is closed.
+func (u *udpinput) readFailed(err error, msg string) bool {
+	select {
+	case <-u.ctx.Done():
+		return false
+	default:
+		//
+	}
+	u.logger.Error().Err(err).Msg(msg)
+	select {
+	case <-u.ctx.Done():
+		return false
+	case <-time.After(readErrorBackoff):
+		return true
+	}
+}
+
+func (u *udpinput) receiveLoop() {
+	buf := make([]byte, readBufferSize)
+	for {
+		n, err := u.c.Read(buf)
+		if err != nil {
+			if !u.readFailed(err, "error reading from udp input") {
+				return
+			}
+			continue
+		}
+		payload := buf[:n]
+		seq := uint16(0)
+		if u.isRtp {
+			payload, seq, err = parseRTP(payload)
+			if err != nil {
+				u.logger.Debug().Err(err).Msg("dropping invalid RTP packet")
+				continue
+			}
+		}
+		if len(payload) == 0 {
+			continue
+		}
+		u.statsLock.Lock()
+		u.status.Packets++
+		u.status.Bytes += n
+		if u.isRtp {
+			u.trackSeq(seq)
+		}
+		u.statsLock.Unlock()
+		if u.fec != nil {
+			u.fecLock.Lock()
+			u.fec.Media(seq, payload, time.Now())
+			u.forward()
+			u.fecLock.Unlock()
+			continue
+		}
+		b := block.Get(len(payload))
+		copy(b.Data, payload)
+		if u.isRtp {
+			u.r.WriteSeqNo(b, seq)
+		} else {
+			u.r.Write(b)
+		}
+	}
+}
+
+// forward hands the media packets the decoder has ready to the receiver, in
+// order, fecLock must be held
+func (u *udpinput) forward() {
+	u.fecReady = u.fec.Ready(time.Now(), u.fecReady[:0])
+	status := u.fec.Status()
+	u.statsLock.Lock()
+	u.fecStatus = status
+	u.statsLock.Unlock()
+	for _, p := range u.fecReady {
+		b := block.Get(len(p.Payload))
+		copy(b.Data, p.Payload)
+		u.r.WriteSeqNo(b, p.Seq)
+	}
+}
+
+func (u *udpinput) fecReceiveLoop(c *net.UDPConn) {
+	buf := make([]byte, readBufferSize)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			if !u.readFailed(err, "error reading from fec input") {
+				return
+			}
+			continue
+		}
+		u.fecLock.Lock()
+		if err := u.fec.FEC(buf[:n]); err != nil {
+			u.logger.Debug().Err(err).Msg("dropping invalid FEC packet")
+		} else {
+			u.forward()
+		}
+		u.fecLock.Unlock()
+	}
+}
+
+// fecFlushLoop forwards held packets once their latency expires when no
+// further packets arrive
+func (u *udpinput) fecFlushLoop() {
+	ticker := time.NewTicker(fecFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.ctx.Done():
+			return
+		case <-ticker.C:
+			//
+		}
+		u.fecLock.Lock()
+		if u.fec.Pending() {
+			u.forward()
+		}
+		u.fecLock.Unlock()
+	}
+}
+
+func (u *udpinput) statsLoop() {
+	for {
+		select {
+		case <-u.ctx.Done():
+			return
+		case <-time.After(time.Duration(stats.StatsIntervalSeconds) * time.Second):
+			//
+		}
+		u.statsLock.Lock()
+		u.status.PacketsTotal += u.status.Packets
+		u.status.BytesTotal += u.status.Bytes
+		stat := u.status
+		u.status.Packets = 0
+		u.status.Bytes = 0
+		u.status.RtpLost = 0
+		u.status.RtpReordered = 0
+		u.statsLock.Unlock()
+		if u.fec != nil {
+			fs, _ := u.FECStatus()
+			stat.FecRecovered = fs.Recovered - u.fecReported.Recovered
+			stat.FecUnrecoverable = fs.Unrecoverable - u.fecReported.Unrecoverable
+			u.fecReported = fs
+		}
+		u.stats.HandleStats("", "", u.u, &stat)
+	}
+}
+
+// interfaceByNameOrAddr finds a network interface either by its name or by
+// one of its ip addresses
+func interfaceByNameOrAddr(iface string) (*net.Interface, error) {
+	if ifi, err := net.InterfaceByName(iface); err == nil {
+		return ifi, nil
+	}
+	ip := net.ParseIP(iface)
+	if ip == nil {
+		return nil, fmt.Errorf("interface %s not found", iface)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface with address %s found", iface)
+}
+
+func reuseAddr(network, address string, c syscall.RawConn) (err error) {
+	var scerr error
+	err = c.Control(func(fd uintptr) {
+		scerr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return
+	}
+	return scerr
+}
+
+func joinGroup(c *net.UDPConn, ifi *net.Interface, group, source net.IP) error {
+	g := &net.UDPAddr{IP: group}
+	if group.To4() != nil {
+		p := ipv4.NewPacketConn(c)
+		if source != nil {
+			return p.JoinSourceSpecificGroup(ifi, g, &net.UDPAddr{IP: source})
+		}
+		return p.JoinGroup(ifi, g)
+	}
+	p := ipv6.NewPacketConn(c)
+	if source != nil {
+		return p.JoinSourceSpecificGroup(ifi, g, &net.UDPAddr{IP: source})
+	}
+	return p.JoinGroup(ifi, g)
+}
+
+// SetupUdpInput creates an udp or rtp input listening on the host and port
+// of u, multicast groups are joined on the interface given by the iface
+// param, source specific when the source param is set.
+func SetupUdpInput(ctx context.Context, u *url.URL, identifier string, r *input.Receiver, s *stats.Stats) (input.Input, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up %s input: %s", u.Scheme, u.String())
+	var in udpinput
+	in.name = u.String()
+	in.u = u
+	in.identifier = identifier
+	in.isRtp = u.Scheme == "rtp"
+	in.r = r
+	in.stats = s
+	in.logger = logging.Log.With().Str("module", "udp-input").Str("identifier", identifier).Str("url", in.name).Logger()
+
+	laddr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ifi    *net.Interface
+		source net.IP
+	)
+	if iface := u.Query().Get("iface"); iface != "" {
+		if ifi, err = interfaceByNameOrAddr(iface); err != nil {
+			return nil, err
+		}
+	}
+	if src := u.Query().Get("source"); src != "" {
+		if source = net.ParseIP(src); source == nil {
+			return nil, fmt.Errorf("invalid source address: %s", src)
+		}
+		if !laddr.IP.IsMulticast() {
+			return nil, errors.New("source requires a multicast group")
+		}
+	}
+
+	var fecParams *fec.Params
+	if in.isRtp {
+		if fecParams, err = fec.ParseParams(u); err != nil {
+			return nil, err
+		}
+	}
+	if in.c, err = in.listen(ctx, laddr, ifi, source); err != nil {
+		return nil, err
+	}
+	if fecParams != nil {
+		ports := []int{laddr.Port + fec.ColumnPortOffset}
+		if fecParams.Rows {
+			ports = append(ports, laddr.Port+fec.RowPortOffset)
+		}
+		for _, port := range ports {
+			c, err := in.listen(ctx, &net.UDPAddr{IP: laddr.IP, Port: port, Zone: laddr.Zone}, ifi, source)
+			if err != nil {
+				in.c.Close()
+				for _, c := range in.fecConns {
+					c.Close()
+				}
+				return nil, fmt.Errorf("fec: %w", err)
+			}
+			in.fecConns = append(in.fecConns, c)
+		}
+		in.fec = fec.NewDecoder(in.name, time.Duration(fecParams.LatencyMS)*time.Millisecond)
+		in.fecStatus = in.fec.Status()
+	}
+	in.ctx, in.cancel = context.WithCancel(ctx)
+	go in.receiveLoop()
+	go in.statsLoop()
+	if in.fec != nil {
+		for _, c := range in.fecConns {
+			go in.fecReceiveLoop(c)
+		}
+		go in.fecFlushLoop()
+	}
+	return &in, nil
+}
+
+// listen opens a socket on laddr, joining it when it is a multicast group
+func (u *udpinput) listen(ctx context.Context, laddr *net.UDPAddr, ifi *net.Interface, source net.IP) (*net.UDPConn, error) {
+	lc := net.ListenConfig{}
+	if laddr.IP.IsMulticast() {
+		lc.Control = reuseAddr
+	}
+	pc, err := lc.ListenPacket(ctx, "udp", laddr.String())
+	if err != nil {
+		return nil, err
+	}
+	c := pc.(*net.UDPConn)
+	if laddr.IP.IsMulticast() {
+		if err := joinGroup(c, ifi, laddr.IP, source); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to join %s: %w", laddr.IP, err)
+		}
+	}
+	if err := c.SetReadBuffer(socketBufferSize); err != nil {
+		u.logger.Warn().Err(err).Msg("failed to set socket receive buffer size")
+	}
+	return c, nil
+}
@@ -0,0 +1,19 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udpstats
+
+type UdpInputStats struct {
+	PacketsTotal int
+	Packets      int
+	BytesTotal   int
+	Bytes        int
+	RtpLost      int
+	RtpReordered int
+	// lost packets rebuilt from FEC and lost packets FEC couldn't rebuild
+	FecRecovered     int
+	FecUnrecoverable int
+}
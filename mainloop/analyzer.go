@@ -0,0 +1,46 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"github.com/odmedia/streamzeug/mainloop/mlstats"
+	"github.com/odmedia/streamzeug/tsanalyzer"
+)
+
+// TR101290Stats returns the total TR 101 290 error counts of the flow
+func (m *Mainloop) TR101290Stats() *mlstats.TR101290Stats {
+	c := m.analyzer.Counts()
+	return &mlstats.TR101290Stats{
+		TSSyncLoss:                     c[tsanalyzer.TSSyncLoss],
+		SyncByteError:                  c[tsanalyzer.SyncByteError],
+		PATError:                       c[tsanalyzer.PATError],
+		ContinuityCountError:           c[tsanalyzer.ContinuityCountError],
+		PMTError:                       c[tsanalyzer.PMTError],
+		PIDError:                       c[tsanalyzer.PIDError],
+		TransportError:                 c[tsanalyzer.TransportError],
+		CRCError:                       c[tsanalyzer.CRCError],
+		PCRRepetitionError:             c[tsanalyzer.PCRRepetitionError],
+		PCRDiscontinuityIndicatorError: c[tsanalyzer.PCRDiscontinuityIndicatorError],
+		PTSError:                       c[tsanalyzer.PTSError],
+		CATError:                       c[tsanalyzer.CATError],
+	}
+}
+
+// PIDStats returns the packet count and bitrate of every PID in the flow
+func (m *Mainloop) PIDStats() []*mlstats.PIDStats {
+	status := m.analyzer.PIDStatus()
+	stats := make([]*mlstats.PIDStats, 0, len(status.PIDs))
+	for _, p := range status.PIDs {
+		stats = append(stats, &mlstats.PIDStats{
+			PID:     int(p.PID),
+			Packets: p.Packets,
+			Bitrate: p.Bitrate,
+			Share:   p.Share,
+		})
+	}
+	return stats
+}
@@ -0,0 +1,343 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+)
+
+const (
+	//delays up to this are buffered in memory, longer delays are spooled to disk
+	memoryDelayMax = 30 * time.Second
+	//timestamps deviating more than this from the expected play out time
+	//re-anchor the pacing, e.g. after a source switch
+	delayResync     = time.Second
+	spoolSegmentMax = 64 << 20
+	//due, timestamp, seqno, discontinuity, length
+	spoolHeaderSize = 8 + 8 + 4 + 1 + 4
+)
+
+// delayBuffer holds the blocks of a delayed output until they are due
+type delayBuffer interface {
+	// push stores rb, taking over its reference
+	push(rb *block.Block, due time.Time) error
+	// peek returns the oldest block and when it is due, nil when empty
+	peek() (*block.Block, time.Time, error)
+	// pop removes the block returned by peek, the caller takes over its
+	// reference
+	pop()
+	close()
+}
+
+// pacer maps block timestamps on the local clock
+type pacer struct {
+	delay    time.Duration
+	offset   time.Duration
+	anchored bool
+	lastDue  time.Time
+}
+
+// due returns when rb, received at now, is to be written
+func (p *pacer) due(rb *block.Block, now time.Time) time.Time {
+	ts := block.FromNTPTime(rb.TimeStamp)
+	expected := ts.Add(p.offset)
+	if !p.anchored || expected.Sub(now) > delayResync || now.Sub(expected) > delayResync {
+		p.offset = now.Sub(ts)
+		p.anchored = true
+		expected = now
+	}
+	due := expected.Add(p.delay)
+	//never reorder
+	if due.Before(p.lastDue) {
+		due = p.lastDue
+	}
+	p.lastDue = due
+	return due
+}
+
+type delayedBlock struct {
+	rb  *block.Block
+	due time.Time
+}
+
+// memoryDelay is a ring of blocks growing as needed
+type memoryDelay struct {
+	ring  []delayedBlock
+	head  int
+	count int
+}
+
+func newMemoryDelay() *memoryDelay {
+	return &memoryDelay{ring: make([]delayedBlock, 1024)}
+}
+
+func (m *memoryDelay) push(rb *block.Block, due time.Time) error {
+	if m.count == len(m.ring) {
+		ring := make([]delayedBlock, 2*len(m.ring))
+		n := copy(ring, m.ring[m.head:])
+		copy(ring[n:], m.ring[:m.head])
+		m.ring = ring
+		m.head = 0
+	}
+	m.ring[(m.head+m.count)%len(m.ring)] = delayedBlock{rb, due}
+	m.count++
+	return nil
+}
+
+func (m *memoryDelay) peek() (*block.Block, time.Time, error) {
+	if m.count == 0 {
+		return nil, time.Time{}, nil
+	}
+	d := m.ring[m.head]
+	return d.rb, d.due, nil
+}
+
+func (m *memoryDelay) pop() {
+	m.ring[m.head] = delayedBlock{}
+	m.head = (m.head + 1) % len(m.ring)
+	m.count--
+}
+
+func (m *memoryDelay) close() {
+	for m.count > 0 {
+		rb, _, _ := m.peek()
+		rb.Return()
+		m.pop()
+	}
+}
+
+type spoolSegment struct {
+	file    *os.File
+	records int
+}
+
+// spoolDelay writes the blocks to segment files in dir, segments are
+// unlinked on creation and closed once read. Only accessed from the output
+// goroutine.
+type spoolDelay struct {
+	dir      string
+	segments []*spoolSegment
+	writer   *bufio.Writer
+	written  int
+	reader   *bufio.Reader
+	head     *block.Block
+	headDue  time.Time
+	header   [spoolHeaderSize]byte
+}
+
+func newSpoolDelay(dir string) *spoolDelay {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &spoolDelay{dir: dir}
+}
+
+func (s *spoolDelay) push(rb *block.Block, due time.Time) error {
+	defer rb.Return()
+	if len(s.segments) == 0 || s.written >= spoolSegmentMax {
+		if err := s.newSegment(); err != nil {
+			return err
+		}
+	}
+	h := s.header[:]
+	binary.BigEndian.PutUint64(h[0:], uint64(due.UnixNano()))
+	binary.BigEndian.PutUint64(h[8:], rb.TimeStamp)
+	binary.BigEndian.PutUint32(h[16:], rb.SeqNo)
+	h[20] = 0
+	if rb.Discontinuity {
+		h[20] = 1
+	}
+	binary.BigEndian.PutUint32(h[21:], uint32(len(rb.Data)))
+	if _, err := s.writer.Write(h); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(rb.Data); err != nil {
+		return err
+	}
+	s.written += spoolHeaderSize + len(rb.Data)
+	s.segments[len(s.segments)-1].records++
+	return nil
+}
+
+func (s *spoolDelay) newSegment() error {
+	if s.writer != nil {
+		if err := s.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	f, err := ioutil.TempFile(s.dir, "streamzeug-delay-*.spool")
+	if err != nil {
+		return err
+	}
+	//only the open file handles are used, so the space is freed when closed
+	os.Remove(f.Name())
+	seg := &spoolSegment{file: f}
+	s.segments = append(s.segments, seg)
+	s.writer = bufio.NewWriterSize(f, 1<<16)
+	s.written = 0
+	if len(s.segments) == 1 {
+		s.reader = nil
+	}
+	return nil
+}
+
+func (s *spoolDelay) peek() (*block.Block, time.Time, error) {
+	if s.head != nil {
+		return s.head, s.headDue, nil
+	}
+	if len(s.segments) == 0 {
+		return nil, time.Time{}, nil
+	}
+	seg := s.segments[0]
+	if seg.records == 0 {
+		if len(s.segments) == 1 {
+			return nil, time.Time{}, nil
+		}
+		seg.file.Close()
+		s.segments = s.segments[1:]
+		s.reader = nil
+		return s.peek()
+	}
+	if len(s.segments) == 1 {
+		//reading the segment being written
+		if err := s.writer.Flush(); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	if s.reader == nil {
+		s.reader = bufio.NewReaderSize(&segmentReader{file: seg.file}, 1<<16)
+	}
+	var h [spoolHeaderSize]byte
+	if _, err := io.ReadFull(s.reader, h[:]); err != nil {
+		return nil, time.Time{}, err
+	}
+	rb := block.Get(int(binary.BigEndian.Uint32(h[21:])))
+	if _, err := io.ReadFull(s.reader, rb.Data); err != nil {
+		rb.Return()
+		return nil, time.Time{}, err
+	}
+	rb.TimeStamp = binary.BigEndian.Uint64(h[8:])
+	rb.SeqNo = binary.BigEndian.Uint32(h[16:])
+	rb.Discontinuity = h[20] == 1
+	seg.records--
+	s.head = rb
+	s.headDue = time.Unix(0, int64(binary.BigEndian.Uint64(h[0:])))
+	return s.head, s.headDue, nil
+}
+
+func (s *spoolDelay) pop() {
+	s.head = nil
+}
+
+func (s *spoolDelay) close() {
+	if s.head != nil {
+		s.head.Return()
+		s.head = nil
+	}
+	for _, seg := range s.segments {
+		seg.file.Close()
+	}
+	s.segments = nil
+	//the next push starts a fresh segment, a writer over a closed file may
+	//hold a sticky error
+	s.writer = nil
+	s.reader = nil
+	s.written = 0
+}
+
+// segmentReader reads a segment at its own offset, as the same file handle
+// is appended to by the writer
+type segmentReader struct {
+	file   *os.File
+	offset int64
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	n, err := r.file.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// delayLoop writes the blocks queued for the output once they are due
+func (o *out) delayLoop() {
+	var buf delayBuffer
+	if o.opts.Delay <= memoryDelayMax {
+		buf = newMemoryDelay()
+	} else {
+		buf = newSpoolDelay(o.opts.SpoolDir)
+	}
+	defer buf.close()
+	p := pacer{delay: o.opts.Delay}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	lastErrMsg := time.Time{}
+	//drops the buffered blocks after a spool error, a broken spool can't be
+	//read back in order
+	reset := func(err error) {
+		if time.Since(lastErrMsg) >= dropMsgInterval {
+			o.m.logger.Error().Err(err).Str("output", o.w.String()).Str("output_identifier", o.opts.Identifier).Msg("delay spool failed, dropping delayed packets")
+			lastErrMsg = time.Now()
+		}
+		buf.close()
+		atomic.AddInt64(&o.counters.droppedPackets, atomic.SwapInt64(&o.counters.delayed, 0))
+	}
+	for {
+		var wait <-chan time.Time
+		rb, due, err := buf.peek()
+		if err != nil {
+			reset(err)
+			continue
+		}
+		if rb != nil {
+			d := time.Until(due)
+			if d <= 0 {
+				buf.pop()
+				atomic.AddInt64(&o.counters.delayed, -1)
+				if err := o.write(rb); err != nil {
+					o.fail(err)
+					return
+				}
+				continue
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(d)
+			wait = timer.C
+		}
+		select {
+		case <-o.c.Done():
+			return
+		case rb, ok := <-o.dataChan:
+			if !ok {
+				return
+			}
+			if err := buf.push(rb, p.due(rb, time.Now())); err != nil {
+				atomic.AddInt64(&o.counters.droppedPackets, 1)
+				reset(err)
+				continue
+			}
+			atomic.AddInt64(&o.counters.delayed, 1)
+		case <-wait:
+			//
+		}
+	}
+}
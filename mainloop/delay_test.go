@@ -0,0 +1,115 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+)
+
+func TestSpoolDelayRecoversAfterError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streamzeug-spool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newSpoolDelay(dir)
+	defer s.close()
+	due := time.Now()
+	if err := s.push(block.New([]byte("before")), due); err != nil {
+		t.Fatal(err)
+	}
+	//break the segment underneath the spool, as a failing disk would
+	s.segments[0].file.Close()
+	if _, _, err := s.peek(); err == nil {
+		t.Fatal("expected an error reading a broken spool")
+	}
+	s.close()
+
+	data := []byte("after")
+	if err := s.push(block.New(data), due); err != nil {
+		t.Fatalf("push after reset: %v", err)
+	}
+	rb, _, err := s.peek()
+	if err != nil {
+		t.Fatalf("peek after reset: %v", err)
+	}
+	if rb == nil || !bytes.Equal(rb.Data, data) {
+		t.Fatalf("expected %q after reset, got %v", data, rb)
+	}
+	s.pop()
+	rb.Return()
+}
+
+type testSource struct {
+	c chan *block.Block
+}
+
+func (s *testSource) DataChannel() <-chan *block.Block {
+	return s.c
+}
+
+// failingOutput fails its writes once fail is closed
+type failingOutput struct {
+	fail chan struct{}
+}
+
+func (o *failingOutput) Close() error {
+	return nil
+}
+
+func (o *failingOutput) Write(*block.Block) (int, error) {
+	<-o.fail
+	return 0, errors.New("write failed")
+}
+
+func (o *failingOutput) String() string {
+	return "failing"
+}
+
+func (o *failingOutput) Count() int {
+	return 0
+}
+
+func TestDelayedOutputReturnsBlocksOnWriteError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := &testSource{make(chan *block.Block)}
+	m := NewMainloop(ctx, source, nil, BackupConfig{}, "test")
+	out := &failingOutput{make(chan struct{})}
+	m.AddOutput(out, OutputOptions{Delay: 10 * time.Millisecond})
+
+	const blocks = 64
+	var returned int32
+	send := func() {
+		source.c <- block.Wrap(make([]byte, 1316), func() {
+			atomic.AddInt32(&returned, 1)
+		})
+	}
+	//once the first block is due its write blocks, the rest queue up behind it
+	send()
+	time.Sleep(50 * time.Millisecond)
+	for i := 1; i < blocks; i++ {
+		send()
+	}
+	close(out.fail)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&returned) != blocks {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d blocks returned after the output failed", atomic.LoadInt32(&returned), blocks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/mainloop/mlstats"
+)
+
+const (
+	SourcePrimary = "primary"
+	SourceBackup  = "backup"
+	SourceAuto    = "auto"
+
+	BackupModeFailover = "failover"
+	BackupModeMerge    = "merge"
+
+	defaultSilenceMS = 500
+	defaultHoldOffMS = 10000
+	failoverInterval = 20 * time.Millisecond
+	bitrateWindow    = time.Second
+)
+
+// BackupConfig configures how the mainloop uses its backup source, either
+// by switching between primary and backup or by merging both.
+type BackupConfig struct {
+	// BackupModeFailover (default) or BackupModeMerge
+	Mode string
+	// ms without packets after which a source is unhealthy, defaults to 500
+	SilenceMS int
+	// ms the primary has to be healthy before switching back, defaults to 10000
+	HoldOffMS int
+	// bitrate under which a source is unhealthy, 0 disables the check
+	MinimalBitrate int
+	// ms a packet is held in merge mode waiting for the other leg, defaults to 50
+	SkewMS int
+}
+
+type SourceStatus struct {
+	Healthy           bool      `json:"healthy"`
+	Bitrate           int       `json:"bitrate"`
+	PacketCount       int       `json:"packetcount"`
+	LastPacketTime    time.Time `json:"lastpackettimestamp"`
+	MsSinceLastPacket int       `json:"mssincelastpacket"`
+}
+
+type FailoverStatus struct {
+	ActiveSource string       `json:"activesource"`
+	Forced       string       `json:"forced,omitempty"`
+	SwitchCount  int          `json:"switchcount"`
+	LastSwitch   time.Time    `json:"lastswitch"`
+	Primary      SourceStatus `json:"primary"`
+	Backup       SourceStatus `json:"backup"`
+}
+
+type sourcestatus struct {
+	packetcount    int
+	lastPacketTime time.Time
+	windowBytes    int
+	windowStart    time.Time
+	bitrate        int
+	bitrateValid   bool
+	healthy        bool
+	healthySince   time.Time
+}
+
+type failover struct {
+	config        BackupConfig
+	silence       time.Duration
+	holdoff       time.Duration
+	active        string
+	forced        string
+	switchCount   int
+	switchesSince int
+	lastSwitch    time.Time
+	started       time.Time
+	sources       map[string]*sourcestatus
+}
+
+func newFailover(c BackupConfig) failover {
+	f := failover{
+		config:  c,
+		silence: time.Duration(c.SilenceMS) * time.Millisecond,
+		holdoff: time.Duration(c.HoldOffMS) * time.Millisecond,
+		active:  SourcePrimary,
+		started: time.Now(),
+		sources: map[string]*sourcestatus{
+			SourcePrimary: {},
+			SourceBackup:  {},
+		},
+	}
+	if f.silence == 0 {
+		f.silence = defaultSilenceMS * time.Millisecond
+	}
+	if f.holdoff == 0 {
+		f.holdoff = defaultHoldOffMS * time.Millisecond
+	}
+	return f
+}
+
+// update recalculates the bitrate over the last window and the health of s
+func (s *sourcestatus) update(now time.Time, f *failover) {
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+	if elapsed := now.Sub(s.windowStart); elapsed >= bitrateWindow {
+		s.bitrate = int(int64(s.windowBytes) * 8 * int64(time.Second) / int64(elapsed))
+		s.bitrateValid = true
+		s.windowBytes = 0
+		s.windowStart = now
+	}
+	healthy := !s.lastPacketTime.IsZero() && now.Sub(s.lastPacketTime) <= f.silence
+	if healthy && f.config.MinimalBitrate > 0 && s.bitrateValid && s.bitrate < f.config.MinimalBitrate {
+		healthy = false
+	}
+	if healthy && !s.healthy {
+		s.healthySince = now
+	}
+	s.healthy = healthy
+}
+
+func (s *sourcestatus) status(now time.Time) SourceStatus {
+	status := SourceStatus{
+		Healthy:        s.healthy,
+		Bitrate:        s.bitrate,
+		PacketCount:    s.packetcount,
+		LastPacketTime: s.lastPacketTime,
+	}
+	if !s.lastPacketTime.IsZero() {
+		status.MsSinceLastPacket = int(now.Sub(s.lastPacketTime).Milliseconds())
+	}
+	return status
+}
+
+// accountSource records the reception of rb from source and returns wether
+// it is to be forwarded to the outputs.
+func (m *Mainloop) accountSource(source string, rb *block.Block) bool {
+	if m.backup == nil {
+		return true
+	}
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	s := m.failover.sources[source]
+	s.packetcount++
+	s.lastPacketTime = time.Now()
+	s.windowBytes += len(rb.Data)
+	return m.failover.active == source
+}
+
+// evaluateFailover switches the active source when needed and returns true
+// when it did, statusLock must be held.
+func (m *Mainloop) evaluateFailover(now time.Time) bool {
+	f := &m.failover
+	primary := f.sources[SourcePrimary]
+	backup := f.sources[SourceBackup]
+	primary.update(now, f)
+	backup.update(now, f)
+
+	//give the primary a chance to start before considering it unhealthy
+	if now.Sub(f.started) < f.silence && f.forced == "" {
+		return false
+	}
+	want := f.active
+	reason := ""
+	switch f.forced {
+	case SourcePrimary, SourceBackup:
+		want = f.forced
+		reason = "forced"
+	default:
+		if f.active == SourcePrimary && !primary.healthy && backup.healthy {
+			want = SourceBackup
+			reason = "primary unhealthy"
+		} else if f.active == SourceBackup && primary.healthy {
+			if !backup.healthy {
+				want = SourcePrimary
+				reason = "backup unhealthy"
+			} else if now.Sub(primary.healthySince) >= f.holdoff {
+				want = SourcePrimary
+				reason = "primary restored"
+			}
+		}
+	}
+	if want == f.active {
+		return false
+	}
+	m.logger.Warn().Str("from", f.active).Str("to", want).Str("reason", reason).
+		Int("primary-bitrate", primary.bitrate).Int("backup-bitrate", backup.bitrate).
+		Msgf("switching from %s to %s source: %s", f.active, want, reason)
+	f.active = want
+	f.switchCount++
+	f.switchesSince++
+	f.lastSwitch = now
+	return true
+}
+
+// ForceSource pins the mainloop to source, SourceAuto restores automatic
+// failover.
+func (m *Mainloop) ForceSource(source string) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	if source == SourceAuto {
+		source = ""
+	}
+	m.logger.Info().Msgf("forced source set to: %s", source)
+	m.failover.forced = source
+}
+
+// SetMinimalBitrate changes the bitrate under which a source is unhealthy,
+// 0 disables the check
+func (m *Mainloop) SetMinimalBitrate(bitrate int) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	m.failover.config.MinimalBitrate = bitrate
+}
+
+// failoverStatus returns the failover status, statusLock must be held
+func (m *Mainloop) failoverStatus(now time.Time) *FailoverStatus {
+	if m.backup == nil || m.merger != nil {
+		return nil
+	}
+	f := &m.failover
+	return &FailoverStatus{
+		ActiveSource: f.active,
+		Forced:       f.forced,
+		SwitchCount:  f.switchCount,
+		LastSwitch:   f.lastSwitch,
+		Primary:      f.sources[SourcePrimary].status(now),
+		Backup:       f.sources[SourceBackup].status(now),
+	}
+}
+
+// FailoverStats returns the failover stats since the previous call
+func (m *Mainloop) FailoverStats() *mlstats.FailoverStats {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	f := &m.failover
+	primary := f.sources[SourcePrimary]
+	backup := f.sources[SourceBackup]
+	stats := &mlstats.FailoverStats{
+		ActiveSource:   f.active,
+		Forced:         f.forced,
+		SwitchCount:    f.switchCount,
+		Switches:       f.switchesSince,
+		PrimaryHealthy: primary.healthy,
+		PrimaryBitrate: primary.bitrate,
+		PrimaryPackets: primary.packetcount,
+		BackupHealthy:  backup.healthy,
+		BackupBitrate:  backup.bitrate,
+		BackupPackets:  backup.packetcount,
+	}
+	f.switchesSince = 0
+	return stats
+}
@@ -11,9 +11,10 @@ import (
 	"sync"
 	"time"
 
-	"code.videolan.org/rist/ristgo"
+	"github.com/odmedia/streamzeug/block"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/output"
+	"github.com/odmedia/streamzeug/tsanalyzer"
 	"github.com/rs/zerolog"
 )
 
@@ -25,14 +26,25 @@ type inputstatus struct {
 	lastPacketTime     time.Time
 }
 
+// Source delivers the data blocks that are fanned out to the outputs, the
+// mainloop takes over the reference to every block received.
+type Source interface {
+	DataChannel() <-chan *block.Block
+}
+
 type Mainloop struct {
 	ctx                context.Context
-	flow               ristgo.ReceiverFlow
+	source             Source
+	backup             Source
+	failover           failover
+	merger             *merger
+	analyzer           *tsanalyzer.Analyzer
 	logger             zerolog.Logger
 	outputs            map[int]*out
-	outPutAdd          chan output.Output
+	outPutAdd          chan outputAdd
 	outPutRemove       chan output.Output
 	outRemoveIdx       chan int
+	sourceSet          chan sourceSet
 	wg                 sync.WaitGroup
 	statusLock         sync.Mutex
 	primaryInputStatus inputstatus
@@ -65,7 +77,8 @@ func (m *Mainloop) deleteOutput(idx int, output output.Output) {
 	delete(m.outputs, idx)
 }
 
-func (m *Mainloop) AddOutput(output output.Output) {
+// AddOutput starts feeding output as configured by opts
+func (m *Mainloop) AddOutput(output output.Output, opts OutputOptions) {
 	m.logger.Info().Msgf("adding output %s", output.String())
 	select {
 	case <-m.ctx.Done():
@@ -73,7 +86,41 @@ func (m *Mainloop) AddOutput(output output.Output) {
 	default:
 		//
 	}
-	m.outPutAdd <- output
+	m.outPutAdd <- outputAdd{output, opts.withDefaults()}
+}
+
+type sourceSet struct {
+	source Source
+	backup Source
+	done   chan struct{}
+}
+
+// SetSources replaces the sources the mainloop reads from while the outputs
+// keep running, a nil source is detached so it can be torn down before its
+// replacement is set up. The backup is ignored for a mainloop created
+// without one. Returns once the previous sources are no longer read from.
+func (m *Mainloop) SetSources(source, backup Source) {
+	set := sourceSet{source, backup, make(chan struct{})}
+	select {
+	case <-m.ctx.Done():
+		return
+	case m.sourceSet <- set:
+		//
+	}
+	select {
+	case <-m.ctx.Done():
+	case <-set.done:
+	}
+}
+
+// sourceReplaced resets the per source state of leg
+func (m *Mainloop) sourceReplaced(leg int) {
+	if m.merger == nil {
+		return
+	}
+	m.statusLock.Lock()
+	m.merger.legs[leg].haveSeq = false
+	m.statusLock.Unlock()
 }
 
 func (m *Mainloop) Wait(timeout time.Duration) {
@@ -90,15 +137,25 @@ func (m *Mainloop) Wait(timeout time.Duration) {
 	}
 }
 
-func NewMainloop(ctx context.Context, flow ristgo.ReceiverFlow, identifier string) *Mainloop {
+// NewMainloop creates a mainloop fanning out the blocks from source, backup
+// may be nil, when set the mainloop fails over to or merges with it as
+// configured in bc.
+func NewMainloop(ctx context.Context, source Source, backup Source, bc BackupConfig, identifier string) *Mainloop {
 	m := &Mainloop{
 		ctx:          ctx,
-		flow:         flow,
+		source:       source,
+		backup:       backup,
+		failover:     newFailover(bc),
 		logger:       logging.Log.With().Str("identifier", identifier).Logger(),
 		outputs:      make(map[int]*out),
-		outPutAdd:    make(chan output.Output, 4),
+		outPutAdd:    make(chan outputAdd, 4),
 		outPutRemove: make(chan output.Output, 4),
 		outRemoveIdx: make(chan int, 16),
+		sourceSet:    make(chan sourceSet),
+	}
+	m.analyzer = tsanalyzer.New(m.logger)
+	if backup != nil && bc.Mode == BackupModeMerge {
+		m.merger = newMerger(bc.SkewMS)
 	}
 	go receiveLoop(m)
 	return m
@@ -109,48 +166,144 @@ func receiveLoop(m *Mainloop) {
 	m.primaryInputStatus.lastPacketTime = time.Now()
 	m.lastStatusCall = m.primaryInputStatus.lastPacketTime
 	expectedSec := uint16(0)
+	resync := false
 	m.logger.Info().Msg("receiver mainloop started")
 	m.wg.Add(1)
 	lastDiscontinuityMsg := time.Time{}
 	discontinuitiesSinceLastMsg := int(0)
+	forward := func(rb *block.Block) {
+		discontinuity := false
+		if rb.Discontinuity {
+			discontinuity = true
+		}
+		if rb.SeqNo != uint32(expectedSec) && !resync {
+			discontinuity = true
+		}
+		resync = false
+		if discontinuity {
+			m.primaryInputStatus.discontinuitycount++
+			discontinuitiesSinceLastMsg++
+		}
+
+		if discontinuitiesSinceLastMsg > 0 && time.Since(lastDiscontinuityMsg) >= time.Duration(5)*time.Second {
+			m.logger.Error().Int("count", discontinuitiesSinceLastMsg).Msg("discontinuity!")
+			lastDiscontinuityMsg = time.Now()
+			discontinuitiesSinceLastMsg = 0
+		}
+		expectedSec = uint16(rb.SeqNo) + 1
+		now := time.Now()
+		m.statusLock.Lock()
+		m.primaryInputStatus.packetcount++
+		m.primaryInputStatus.packetcountsince++
+		m.primaryInputStatus.lastPacketTime = now
+		m.primaryInputStatus.bytesSince += len(rb.Data)
+		m.statusLock.Unlock()
+		m.analyzer.Feed(rb.Data, now)
+		m.writeOutputs(rb)
+	}
+	var (
+		backupChan   <-chan *block.Block
+		backupTick   <-chan time.Time
+		merged       []*block.Block
+		tickInterval = failoverInterval
+	)
+	merge := func(leg int, rb *block.Block) {
+		m.statusLock.Lock()
+		merged = m.merger.push(leg, rb, time.Now(), merged[:0])
+		m.statusLock.Unlock()
+		for _, rb := range merged {
+			forward(rb)
+		}
+	}
+	//the sources currently read from, changed by SetSources
+	curSource, curBackup := m.source, m.backup
+	sourceChan := curSource.DataChannel()
+	if m.backup != nil {
+		backupChan = m.backup.DataChannel()
+		if m.merger != nil {
+			tickInterval = mergeInterval
+			defer m.merger.release()
+		}
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		backupTick = ticker.C
+	}
 main:
 	for {
 		select {
 		case <-m.ctx.Done():
 			break main
-
-		case rb, ok := <-m.flow.DataChannel():
+		case rb, ok := <-sourceChan:
 			if !ok {
-				break main
+				if m.backup == nil {
+					break main
+				}
+				//the failover sees the primary fall silent
+				sourceChan = nil
+				continue
 			}
-			discontinuity := false
-			if rb.Discontinuity {
-				discontinuity = true
+			if m.merger != nil {
+				merge(legPrimary, rb)
+			} else if m.accountSource(SourcePrimary, rb) {
+				forward(rb)
+			} else {
+				rb.Return()
 			}
-			if rb.SeqNo != uint32(expectedSec) {
-				discontinuity = true
+		case rb, ok := <-backupChan:
+			if !ok {
+				backupChan = nil
+				continue
 			}
-			if discontinuity {
-				m.primaryInputStatus.discontinuitycount++
-				discontinuitiesSinceLastMsg++
+			if m.merger != nil {
+				merge(legBackup, rb)
+			} else if m.accountSource(SourceBackup, rb) {
+				forward(rb)
+			} else {
+				rb.Return()
 			}
-
-			if discontinuitiesSinceLastMsg > 0 && time.Since(lastDiscontinuityMsg) >= time.Duration(5)*time.Second {
-				m.logger.Error().Int("count", discontinuitiesSinceLastMsg).Msg("discontinuity!")
-				lastDiscontinuityMsg = time.Now()
-				discontinuitiesSinceLastMsg = 0
+		case now := <-backupTick:
+			if m.merger != nil {
+				m.statusLock.Lock()
+				merged = m.merger.flush(now, merged[:0])
+				m.statusLock.Unlock()
+				for _, rb := range merged {
+					forward(rb)
+				}
+				continue
 			}
-			expectedSec = uint16(rb.SeqNo) + 1
 			m.statusLock.Lock()
-			m.primaryInputStatus.packetcount++
-			m.primaryInputStatus.packetcountsince++
-			m.primaryInputStatus.lastPacketTime = time.Now()
-			m.primaryInputStatus.bytesSince += len(rb.Data)
+			if m.evaluateFailover(now) {
+				//sequence numbers of both sources are unrelated
+				expectedSec = 0
+				resync = true
+			}
 			m.statusLock.Unlock()
-			m.writeOutputs(rb)
-		case output := <-m.outPutAdd:
+		case set := <-m.sourceSet:
+			if set.source != curSource {
+				m.logger.Info().Msg("primary source replaced")
+				curSource = set.source
+				sourceChan = nil
+				if set.source != nil {
+					sourceChan = set.source.DataChannel()
+				}
+				m.sourceReplaced(legPrimary)
+			}
+			if m.backup != nil && set.backup != curBackup {
+				m.logger.Info().Msg("backup source replaced")
+				curBackup = set.backup
+				backupChan = nil
+				if set.backup != nil {
+					backupChan = set.backup.DataChannel()
+				}
+				m.sourceReplaced(legBackup)
+			}
+			//sequence numbers of a new source are unrelated
+			expectedSec = 0
+			resync = true
+			close(set.done)
+		case add := <-m.outPutAdd:
 			m.statusLock.Lock()
-			m.addOutput(output, outputidx)
+			m.addOutput(add, outputidx)
 			outputidx++
 			m.statusLock.Unlock()
 		case idx := <-m.outRemoveIdx:
@@ -0,0 +1,238 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/mainloop/mlstats"
+)
+
+const (
+	defaultSkewMS = 50
+	//packets further behind than this are no longer tracked for the saved
+	//counters, and sequence jumps larger than this restart the merge
+	mergeWindow   = 4096
+	mergeInterval = 5 * time.Millisecond
+	legPrimary    = 0
+	legBackup     = 1
+	//marks a sequence number as skipped, late arrivals didn't save anything
+	legSkipped = 1 << 7
+)
+
+type LegStatus struct {
+	PacketCount  int `json:"packetcount"`
+	PacketsSince int `json:"packetssince"`
+	Lost         int `json:"lost"`
+	Saved        int `json:"saved"`
+	Duplicates   int `json:"duplicates"`
+}
+
+type MergeStatus struct {
+	SkewMS      int       `json:"skewms"`
+	Unrecovered int       `json:"unrecovered"`
+	Primary     LegStatus `json:"primary"`
+	Backup      LegStatus `json:"backup"`
+}
+
+type legstatus struct {
+	inputstatus
+	lost       int
+	saved      int
+	duplicates int
+	haveSeq    bool
+	expectSeq  uint16
+}
+
+// merger combines two streams carrying the same sequence numbers into a
+// single stream (SMPTE 2022-7), packets are held at most skew to wait for
+// the other leg to fill a gap.
+type merger struct {
+	skew        time.Duration
+	started     bool
+	next        uint16
+	newest      uint16
+	pending     [65536]*block.Block
+	arrival     [65536]time.Time
+	pendingCnt  int
+	legmask     [65536]uint8
+	unrecovered int
+	legs        [2]legstatus
+}
+
+func newMerger(skewMS int) *merger {
+	if skewMS == 0 {
+		skewMS = defaultSkewMS
+	}
+	return &merger{skew: time.Duration(skewMS) * time.Millisecond}
+}
+
+func (l *legstatus) account(seq uint16, now time.Time, size int) {
+	l.packetcount++
+	l.packetcountsince++
+	l.bytesSince += size
+	l.lastPacketTime = now
+	if !l.haveSeq {
+		l.haveSeq = true
+		l.expectSeq = seq + 1
+		return
+	}
+	diff := int16(seq - l.expectSeq)
+	switch {
+	case diff == 0:
+		l.expectSeq++
+	case diff > 0 && diff < mergeWindow:
+		l.lost += int(diff)
+		l.discontinuitycount++
+		l.expectSeq = seq + 1
+	case diff < 0 && diff > -mergeWindow:
+		//late packet on this leg
+	default:
+		l.expectSeq = seq + 1
+	}
+}
+
+// retire updates the saved counters for seq, which has left the window
+func (mg *merger) retire(seq uint16) {
+	switch mg.legmask[seq] {
+	case 1 << legPrimary:
+		mg.legs[legPrimary].saved++
+	case 1 << legBackup:
+		mg.legs[legBackup].saved++
+	}
+	mg.legmask[seq] = 0
+}
+
+// release returns all pending blocks
+func (mg *merger) release() {
+	for mg.pendingCnt > 0 {
+		if mg.pending[mg.next] != nil {
+			mg.pending[mg.next].Return()
+			mg.pending[mg.next] = nil
+			mg.pendingCnt--
+		}
+		mg.next++
+	}
+}
+
+func (mg *merger) reset(seq uint16) {
+	mg.release()
+	mg.legmask = [65536]uint8{}
+	mg.next = seq
+	mg.newest = seq
+}
+
+// push adds rb received on leg and returns the blocks ready to be forwarded
+// in sequence order.
+func (mg *merger) push(leg int, rb *block.Block, now time.Time, out []*block.Block) []*block.Block {
+	seq := uint16(rb.SeqNo)
+	mg.legs[leg].account(seq, now, len(rb.Data))
+	if !mg.started {
+		mg.started = true
+		mg.next = seq
+		mg.newest = seq
+	}
+	diff := int16(seq - mg.next)
+	if diff >= mergeWindow || diff <= -mergeWindow {
+		mg.reset(seq)
+		diff = 0
+	}
+	if diff < 0 || mg.pending[seq] != nil {
+		if mg.legmask[seq]&(1<<leg) == 0 {
+			mg.legmask[seq] |= 1 << leg
+		} else {
+			mg.legs[leg].duplicates++
+		}
+		rb.Return()
+		return mg.flush(now, out)
+	}
+	mg.legmask[seq] |= 1 << leg
+	mg.pending[seq] = rb
+	mg.arrival[seq] = now
+	mg.pendingCnt++
+	if int16(seq-mg.newest) > 0 {
+		mg.newest = seq
+	}
+	return mg.flush(now, out)
+}
+
+// flush returns the blocks that can be forwarded, gaps are skipped once a
+// later packet has waited longer than skew.
+func (mg *merger) flush(now time.Time, out []*block.Block) []*block.Block {
+	for mg.pendingCnt > 0 {
+		if rb := mg.pending[mg.next]; rb != nil {
+			out = append(out, rb)
+			mg.pending[mg.next] = nil
+			mg.pendingCnt--
+			mg.retire(mg.next - mergeWindow)
+			mg.next++
+			continue
+		}
+		//find the first packet after the gap
+		seq := mg.next + 1
+		for mg.pending[seq] == nil && seq != mg.newest {
+			seq++
+		}
+		if now.Sub(mg.arrival[seq]) < mg.skew {
+			break
+		}
+		for mg.next != seq {
+			mg.unrecovered++
+			mg.retire(mg.next - mergeWindow)
+			mg.legmask[mg.next] = legSkipped
+			mg.next++
+		}
+	}
+	return out
+}
+
+// mergeStatus returns the merge status, the PacketsSince windows are
+// restarted when reset is set. statusLock must be held
+func (m *Mainloop) mergeStatus(reset bool) *MergeStatus {
+	if m.merger == nil {
+		return nil
+	}
+	legStatus := func(l *legstatus) LegStatus {
+		s := LegStatus{
+			PacketCount:  l.packetcount,
+			PacketsSince: l.packetcountsince,
+			Lost:         l.lost,
+			Saved:        l.saved,
+			Duplicates:   l.duplicates,
+		}
+		if reset {
+			l.packetcountsince = 0
+			l.bytesSince = 0
+		}
+		return s
+	}
+	return &MergeStatus{
+		SkewMS:      int(m.merger.skew.Milliseconds()),
+		Unrecovered: m.merger.unrecovered,
+		Primary:     legStatus(&m.merger.legs[legPrimary]),
+		Backup:      legStatus(&m.merger.legs[legBackup]),
+	}
+}
+
+// MergeStats returns the cumulative merge counters
+func (m *Mainloop) MergeStats() *mlstats.MergeStats {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	mg := m.merger
+	return &mlstats.MergeStats{
+		Unrecovered:       mg.unrecovered,
+		PrimaryPackets:    mg.legs[legPrimary].packetcount,
+		PrimaryLost:       mg.legs[legPrimary].lost,
+		PrimarySaved:      mg.legs[legPrimary].saved,
+		PrimaryDuplicates: mg.legs[legPrimary].duplicates,
+		BackupPackets:     mg.legs[legBackup].packetcount,
+		BackupLost:        mg.legs[legBackup].lost,
+		BackupSaved:       mg.legs[legBackup].saved,
+		BackupDuplicates:  mg.legs[legBackup].duplicates,
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mlstats
+
+type FailoverStats struct {
+	ActiveSource   string
+	Forced         string
+	SwitchCount    int
+	Switches       int
+	PrimaryHealthy bool
+	PrimaryBitrate int
+	PrimaryPackets int
+	BackupHealthy  bool
+	BackupBitrate  int
+	BackupPackets  int
+}
+
+type MergeStats struct {
+	Unrecovered       int
+	PrimaryPackets    int
+	PrimaryLost       int
+	PrimarySaved      int
+	PrimaryDuplicates int
+	BackupPackets     int
+	BackupLost        int
+	BackupSaved       int
+	BackupDuplicates  int
+}
+
+type TR101290Stats struct {
+	TSSyncLoss                     int
+	SyncByteError                  int
+	PATError                       int
+	ContinuityCountError           int
+	PMTError                       int
+	PIDError                       int
+	TransportError                 int
+	CRCError                       int
+	PCRRepetitionError             int
+	PCRDiscontinuityIndicatorError int
+	PTSError                       int
+	CATError                       int
+}
+
+type PIDStats struct {
+	PID     int
+	Packets int
+	Bitrate int
+	Share   float64
+}
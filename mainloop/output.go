@@ -8,38 +8,136 @@ package mainloop
 
 import (
 	"context"
+	"sort"
+	"sync/atomic"
+	"time"
 
-	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/odmedia/streamzeug/block"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/output"
 )
 
+const (
+	defaultQueueDepth = 256
+	//weight of the previous average in the write latency moving average
+	latencyWeight   = 15
+	dropMsgInterval = 5 * time.Second
+)
+
+// OutputOptions configure how the mainloop feeds an output
+type OutputOptions struct {
+	// unique key of the output in the flow config
+	Key string
+	// identifier of the output in the flow config
+	Identifier string
+	// blocks queued for the output before dropping, defaults to 256
+	QueueDepth int
+	// time the output plays out behind the flow, 0 disables the delay
+	Delay time.Duration
+	// directory for the disk spool of delays over 30s, defaults to the
+	// system temp directory
+	SpoolDir string
+}
+
+func (opts OutputOptions) withDefaults() OutputOptions {
+	if opts.QueueDepth <= 0 {
+		opts.QueueDepth = defaultQueueDepth
+	}
+	return opts
+}
+
+type OutputStatus struct {
+	Key        string `json:"-"`
+	Identifier string `json:"identifier,omitempty"`
+	Name       string `json:"name"`
+	output.Status
+	// restarts of a failed output by the flow
+	Restarts          int        `json:"restarts"`
+	NextRestart       *time.Time `json:"nextrestart,omitempty"`
+	GaveUp            bool       `json:"gaveup,omitempty"`
+	Count             int        `json:"count"`
+	QueueDepth        int        `json:"queuedepth"`
+	Queued            int        `json:"queued"`
+	HighWater         int        `json:"highwater"`
+	QueuedPackets     int        `json:"queuedpackets"`
+	QueuedBytes       int        `json:"queuedbytes"`
+	WrittenPackets    int        `json:"writtenpackets"`
+	WrittenBytes      int        `json:"writtenbytes"`
+	DroppedPackets    int        `json:"droppedpackets"`
+	DroppedBytes      int        `json:"droppedbytes"`
+	WriteLatencyUS    int        `json:"writelatencyus"`
+	MaxWriteLatencyUS int        `json:"maxwritelatencyus"`
+	DelayMS           int        `json:"delayms,omitempty"`
+	// blocks held back by the delay
+	Delayed int `json:"delayed,omitempty"`
+}
+
+// outcounters are updated with atomics, kept at the start of out for 64 bit
+// alignment
+type outcounters struct {
+	queuedPackets  int64
+	queuedBytes    int64
+	writtenPackets int64
+	writtenBytes   int64
+	droppedPackets int64
+	droppedBytes   int64
+	highWater      int64
+	latency        int64
+	maxLatency     int64
+	delayed        int64
+}
+
+type outputAdd struct {
+	w    output.Output
+	opts OutputOptions
+}
+
 type out struct {
-	c        context.Context
-	w        output.Output
-	i        int
-	m        *Mainloop
-	dataChan chan *libristwrapper.RistDataBlock
+	counters      outcounters
+	c             context.Context
+	w             output.Output
+	i             int
+	m             *Mainloop
+	dataChan      chan *block.Block
+	opts          OutputOptions
+	lastDropMsg   time.Time
+	dropsSinceMsg int
 }
 
-func (m *Mainloop) addOutput(w output.Output, i int) {
+func (m *Mainloop) addOutput(add outputAdd, i int) {
 	o := &out{
-		m.ctx,
-		w,
-		i,
-		m,
-		make(chan *libristwrapper.RistDataBlock, 256),
+		c:        m.ctx,
+		w:        add.w,
+		i:        i,
+		m:        m,
+		dataChan: make(chan *block.Block, add.opts.QueueDepth),
+		opts:     add.opts,
+	}
+	if add.opts.Delay > 0 {
+		go o.delayLoop()
+	} else {
+		go o.loop()
 	}
-	go o.loop()
 	m.outputs[i] = o
 }
 
-func (o *out) write(rb *libristwrapper.RistDataBlock) error {
+func (o *out) write(rb *block.Block) error {
 	defer rb.Return()
-	_, err := o.w.Write(rb)
+	start := time.Now()
+	n, err := o.w.Write(rb)
 	if err != nil {
 		return err
 	}
+	latency := int64(time.Since(start))
+	c := &o.counters
+	atomic.AddInt64(&c.writtenPackets, 1)
+	atomic.AddInt64(&c.writtenBytes, int64(n))
+	//only the output goroutine writes the latency fields
+	avg := atomic.LoadInt64(&c.latency)
+	atomic.StoreInt64(&c.latency, (avg*latencyWeight+latency)/(latencyWeight+1))
+	if latency > atomic.LoadInt64(&c.maxLatency) {
+		atomic.StoreInt64(&c.maxLatency, latency)
+	}
 	return nil
 }
 
@@ -51,19 +149,112 @@ func (o *out) loop() {
 		case rb := <-o.dataChan:
 			err := o.write(rb)
 			if err != nil {
-				logging.Log.Error().Err(err).Msg("error writing to output")
-				o.m.removeOutputByID(o.i)
-				for rb := range o.dataChan {
-					rb.Return()
-				}
+				o.fail(err)
 				return
 			}
 		}
 	}
 }
 
-func (m *Mainloop) writeOutputs(rb *libristwrapper.RistDataBlock) {
+// fail removes the output after a write error and returns the blocks still
+// queued for it
+func (o *out) fail(err error) {
+	logging.Log.Error().Err(err).Msg("error writing to output")
+	o.m.removeOutputByID(o.i)
+	for rb := range o.dataChan {
+		rb.Return()
+	}
+}
+
+// queued accounts a block queued for the output, called from receiveLoop
+func (o *out) queued(size int) {
+	c := &o.counters
+	atomic.AddInt64(&c.queuedPackets, 1)
+	atomic.AddInt64(&c.queuedBytes, int64(size))
+	if depth := int64(len(o.dataChan)); depth > atomic.LoadInt64(&c.highWater) {
+		atomic.StoreInt64(&c.highWater, depth)
+	}
+}
+
+// dropped accounts a block dropped because the queue is full, called from
+// receiveLoop
+func (o *out) dropped(size int) {
+	atomic.AddInt64(&o.counters.droppedPackets, 1)
+	atomic.AddInt64(&o.counters.droppedBytes, int64(size))
+	o.dropsSinceMsg++
+	if time.Since(o.lastDropMsg) >= dropMsgInterval {
+		o.m.logger.Warn().Str("output", o.w.String()).Str("output_identifier", o.opts.Identifier).Int("count", o.dropsSinceMsg).Int("queuedepth", o.opts.QueueDepth).Msg("output queue full, dropping packets")
+		o.lastDropMsg = time.Now()
+		o.dropsSinceMsg = 0
+	}
+}
+
+func (o *out) status() OutputStatus {
+	c := &o.counters
+	return OutputStatus{
+		Key:               o.opts.Key,
+		Identifier:        o.opts.Identifier,
+		Name:              o.w.String(),
+		Status:            output.Status{State: output.StateActive},
+		Count:             o.w.Count(),
+		QueueDepth:        o.opts.QueueDepth,
+		Queued:            len(o.dataChan),
+		HighWater:         int(atomic.LoadInt64(&c.highWater)),
+		QueuedPackets:     int(atomic.LoadInt64(&c.queuedPackets)),
+		QueuedBytes:       int(atomic.LoadInt64(&c.queuedBytes)),
+		WrittenPackets:    int(atomic.LoadInt64(&c.writtenPackets)),
+		WrittenBytes:      int(atomic.LoadInt64(&c.writtenBytes)),
+		DroppedPackets:    int(atomic.LoadInt64(&c.droppedPackets)),
+		DroppedBytes:      int(atomic.LoadInt64(&c.droppedBytes)),
+		WriteLatencyUS:    int(time.Duration(atomic.LoadInt64(&c.latency)).Microseconds()),
+		MaxWriteLatencyUS: int(time.Duration(atomic.LoadInt64(&c.maxLatency)).Microseconds()),
+		DelayMS:           int(o.opts.Delay.Milliseconds()),
+		Delayed:           int(atomic.LoadInt64(&c.delayed)),
+	}
+}
+
+// Accumulate adds the counters of o, for outputs fed by multiple queues
+// such as listeners with multiple clients
+func (s *OutputStatus) Accumulate(o *OutputStatus) {
+	s.QueueDepth = o.QueueDepth
+	s.Queued += o.Queued
+	s.QueuedPackets += o.QueuedPackets
+	s.QueuedBytes += o.QueuedBytes
+	s.WrittenPackets += o.WrittenPackets
+	s.WrittenBytes += o.WrittenBytes
+	s.DroppedPackets += o.DroppedPackets
+	s.DroppedBytes += o.DroppedBytes
+	s.DelayMS = o.DelayMS
+	s.Delayed += o.Delayed
+	if o.HighWater > s.HighWater {
+		s.HighWater = o.HighWater
+	}
+	if o.WriteLatencyUS > s.WriteLatencyUS {
+		s.WriteLatencyUS = o.WriteLatencyUS
+	}
+	if o.MaxWriteLatencyUS > s.MaxWriteLatencyUS {
+		s.MaxWriteLatencyUS = o.MaxWriteLatencyUS
+	}
+}
+
+// outputStatus returns the status of all outputs in the order they were
+// added, statusLock must be held
+func (m *Mainloop) outputStatus() []OutputStatus {
+	idx := make([]int, 0, len(m.outputs))
+	for i := range m.outputs {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	status := make([]OutputStatus, 0, len(idx))
+	for _, i := range idx {
+		status = append(status, m.outputs[i].status())
+	}
+	return status
+}
+
+func (m *Mainloop) writeOutputs(rb *block.Block) {
 	if len(rb.Data) == 0 {
+		rb.Return()
 		return
 	}
 	if len(m.outputs) == 0 {
@@ -74,8 +265,9 @@ func (m *Mainloop) writeOutputs(rb *libristwrapper.RistDataBlock) {
 		rb.Increment()
 		select {
 		case out.dataChan <- rb:
-			//
+			out.queued(len(rb.Data))
 		default:
+			out.dropped(len(rb.Data))
 			rb.Return()
 		}
 	}
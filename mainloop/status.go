@@ -6,20 +6,45 @@
 
 package mainloop
 
-import "time"
+import (
+	"time"
+
+	"github.com/odmedia/streamzeug/fec"
+	"github.com/odmedia/streamzeug/tsanalyzer"
+)
 
 type Status struct {
-	OK                bool      `json:"-"`
-	Status            string    `json:"status"`
-	LastPacketTime    time.Time `json:"lastpackettimestamp"`
-	MsSinceLastPacket int       `json:"mssincelastpacket"`
-	PacketCount       int       `json:"packetcount"`
-	PacketsSince      int       `json:"packetssince"`
-	OutputCount       int       `json:"outputcount"`
-	Bitrate           int       `json:"bitrate"`
+	OK                bool                  `json:"-"`
+	Status            string                `json:"status"`
+	LastPacketTime    time.Time             `json:"lastpackettimestamp"`
+	MsSinceLastPacket int                   `json:"mssincelastpacket"`
+	PacketCount       int                   `json:"packetcount"`
+	PacketsSince      int                   `json:"packetssince"`
+	OutputCount       int                   `json:"outputcount"`
+	Bitrate           int                   `json:"bitrate"`
+	Failover          *FailoverStatus       `json:"failover,omitempty"`
+	Merge             *MergeStatus          `json:"merge,omitempty"`
+	FEC               []fec.Status          `json:"fec,omitempty"`
+	TR101290          *tsanalyzer.Status    `json:"tr101290"`
+	Services          []tsanalyzer.Service  `json:"services"`
+	PIDStatus         *tsanalyzer.PIDStatus `json:"pidstatus"`
+	Outputs           []OutputStatus        `json:"outputs"`
 }
 
+// Status returns the status of the mainloop, the bitrate and PacketsSince
+// cover the time since the previous call
 func (m *Mainloop) Status() *Status {
+	return m.status(true)
+}
+
+// Snapshot returns the status like Status without restarting the bitrate
+// and PacketsSince windows, for readers such as metrics scrapes that must
+// not affect the status polls
+func (m *Mainloop) Snapshot() *Status {
+	return m.status(false)
+}
+
+func (m *Mainloop) status(reset bool) *Status {
 	m.statusLock.Lock()
 	defer m.statusLock.Unlock()
 	var status Status
@@ -31,10 +56,18 @@ func (m *Mainloop) Status() *Status {
 	status.PacketsSince = m.primaryInputStatus.packetcountsince
 	status.LastPacketTime = m.primaryInputStatus.lastPacketTime
 	status.OutputCount = len(m.outputs)
+	status.Outputs = m.outputStatus()
+	status.Failover = m.failoverStatus(now)
+	status.Merge = m.mergeStatus(reset)
+	status.TR101290 = m.analyzer.Status()
+	status.Services = m.analyzer.Services()
+	status.PIDStatus = m.analyzer.PIDStatus()
 
-	m.primaryInputStatus.bytesSince = 0
-	m.primaryInputStatus.packetcountsince = 0
-	m.lastStatusCall = now
+	if reset {
+		m.primaryInputStatus.bytesSince = 0
+		m.primaryInputStatus.packetcountsince = 0
+		m.lastStatusCall = now
+	}
 
 	status.Status = "OK"
 	status.OK = true
@@ -0,0 +1,19 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package clientstats
+
+// ClientStats are reported for every client of a stream output serving
+// multiple clients, totals since the client connected
+type ClientStats struct {
+	ConnectedMS int
+	BytesTotal  int
+	Bytes       int
+	BlocksTotal int
+	Blocks      int
+	// blocks queued for the client, it is disconnected when its queue is full
+	Queued int
+}
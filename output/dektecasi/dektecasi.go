@@ -24,7 +24,7 @@ import (
 	"time"
 	"unsafe"
 
-	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/odmedia/streamzeug/block"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/output"
@@ -43,6 +43,7 @@ type dektecasi struct {
 	logCBPtr          unsafe.Pointer
 	stats             *stats.Stats
 	dektecCtx         C.dektec_asi_ctx_t
+	state             *output.StateTracker
 }
 
 func (d *dektecasi) statsloop() {
@@ -72,10 +73,16 @@ func (d *dektecasi) Count() int {
 	return 1
 }
 
-func (d *dektecasi) Write(block *libristwrapper.RistDataBlock) (n int, err error) {
+func (d *dektecasi) Status() output.Status {
+	return d.state.Status()
+}
+
+func (d *dektecasi) Write(block *block.Block) (n int, err error) {
 	select {
 	case <-d.ctx.Done():
-		return 0, errors.New("output stopped")
+		err = errors.New("output stopped")
+		d.state.Failed(output.StateFailed, err)
+		return 0, err
 	default:
 		//
 	}
@@ -90,7 +97,7 @@ func (d *dektecasi) Close() error {
 	return nil
 }
 
-func ParseURL(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, stats *stats.Stats) (output.Output, error) {
+func ParseURL(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions, stats *stats.Stats) (output.Output, error) {
 	var err error
 	logging.Log.Info().Str("identifier", identifier).Msgf("setting up dektec asi output: %s", u.String())
 	sDektecport := u.Port()
@@ -133,8 +140,9 @@ func ParseURL(ctx context.Context, u *url.URL, identifier, output_identifier str
 		logCBPtr:          logCBPtr,
 		stats:             stats,
 		dektecCtx:         dektecasictx,
+		state:             output.NewStateTracker(output.StateActive),
 	}
 	go out.statsloop()
-	out.m.AddOutput(out)
+	out.m.AddOutput(out, opts)
 	return out, nil
 }
@@ -0,0 +1,88 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package file
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/output"
+	"github.com/odmedia/streamzeug/recording"
+)
+
+type fileoutput struct {
+	lock       sync.Mutex
+	rec        *recording.Recorder
+	closed     bool
+	name       string
+	identifier string
+	state      *output.StateTracker
+}
+
+func (f *fileoutput) String() string {
+	return f.name
+}
+
+func (f *fileoutput) Count() int {
+	return 1
+}
+
+func (f *fileoutput) Status() output.Status {
+	return f.state.Status()
+}
+
+func (f *fileoutput) Write(block *block.Block) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return 0, nil
+	}
+	if err = f.rec.Write(block.Data, time.Now()); err != nil {
+		logging.Log.Error().Str("identifier", f.identifier).Err(err).Msgf("recording to %s failed", f.name)
+		f.state.Failed(output.StateFailed, err)
+		return 0, err
+	}
+	return len(block.Data), nil
+}
+
+func (f *fileoutput) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.rec.Close()
+}
+
+// ParseFileOutput sets up a recording of the flow to the directory in u,
+// segments are named after the flow identifier
+func ParseFileOutput(ctx context.Context, u *url.URL, identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up file output: %s", u.String())
+	ropts, err := recording.ParseURL(u)
+	if err != nil {
+		return nil, err
+	}
+	ropts.Prefix = identifier
+	rec, err := recording.New(ropts)
+	if err != nil {
+		return nil, err
+	}
+	out := &fileoutput{
+		rec:        rec,
+		name:       u.String(),
+		identifier: identifier,
+		state:      output.NewStateTracker(output.StateActive),
+	}
+	m.AddOutput(out, opts)
+	return out, nil
+}
@@ -0,0 +1,216 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package hls
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/output"
+	"github.com/odmedia/streamzeug/tsanalyzer"
+)
+
+const (
+	DefaultSegmentSeconds = 6
+	DefaultWindow         = 6
+	//segments kept after leaving the playlist, for clients still fetching
+	//them
+	keepSegments = 2
+	//segments are cut on any video PES start when no random access point
+	//is flagged for this many times the segment duration
+	forceCutFactor = 3
+)
+
+type segment struct {
+	seq           int
+	duration      time.Duration
+	discontinuity bool
+	data          []byte
+}
+
+// hlsoutput repackages the flow into rolling segments and a live playlist,
+// served over http or written to a directory
+type hlsoutput struct {
+	lock       sync.Mutex
+	name       string
+	identifier string
+	dir        string
+	target     time.Duration
+	window     int
+	splitter   *tsanalyzer.Splitter
+	state      *output.StateTracker
+	closed     bool
+	//segment being built
+	cur           []byte
+	started       bool
+	curStart      time.Time
+	curClock      time.Duration
+	curHasClock   bool
+	discontinuity bool
+	seq           int
+	//finished segments, oldest first
+	segments []*segment
+	//discontinuities in segments dropped from segments
+	discontinuitySeq int
+}
+
+func (h *hlsoutput) String() string {
+	return h.name
+}
+
+func (h *hlsoutput) Count() int {
+	return 1
+}
+
+func (h *hlsoutput) Status() output.Status {
+	return h.state.Status()
+}
+
+func (h *hlsoutput) Write(block *block.Block) (n int, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return 0, nil
+	}
+	now := time.Now()
+	for data := block.Data; len(data) >= tsanalyzer.PacketSize; data = data[tsanalyzer.PacketSize:] {
+		p := data[:tsanalyzer.PacketSize]
+		sp := h.splitter.Packet(p)
+		if h.splitter.Discontinuity() {
+			h.discontinuity = true
+		}
+		if sp != tsanalyzer.SplitNone && h.shouldCut(sp, now) {
+			if h.started {
+				if err = h.finish(now); err != nil {
+					logging.Log.Error().Str("identifier", h.identifier).Err(err).Msgf("hls output %s failed", h.name)
+					h.state.Failed(output.StateFailed, err)
+					return 0, err
+				}
+			}
+			h.start(now)
+		}
+		if !h.started {
+			//segments start on a random access point
+			continue
+		}
+		h.cur = append(h.cur, p...)
+	}
+	return len(block.Data), nil
+}
+
+// elapsed returns the duration of the segment being built, on the PCR when
+// available
+func (h *hlsoutput) elapsed(now time.Time) time.Duration {
+	if clock, ok := h.splitter.Clock(); ok && h.curHasClock {
+		return clock - h.curClock
+	}
+	return now.Sub(h.curStart)
+}
+
+func (h *hlsoutput) shouldCut(sp tsanalyzer.SplitPoint, now time.Time) bool {
+	if !h.started {
+		return sp == tsanalyzer.SplitRandomAccess && h.splitter.PSI() != nil
+	}
+	elapsed := h.elapsed(now)
+	switch sp {
+	case tsanalyzer.SplitRandomAccess:
+		return h.discontinuity || elapsed >= h.target
+	case tsanalyzer.SplitUnit:
+		return elapsed >= forceCutFactor*h.target
+	}
+	return false
+}
+
+// start begins a segment with the PAT and PMT, so it can be decoded on its
+// own
+func (h *hlsoutput) start(now time.Time) {
+	if !h.started {
+		h.discontinuity = false
+	}
+	h.cur = append(make([]byte, 0, cap(h.cur)), h.splitter.PSI()...)
+	h.started = true
+	h.curStart = now
+	h.curClock, h.curHasClock = h.splitter.Clock()
+}
+
+func (h *hlsoutput) finish(now time.Time) error {
+	s := &segment{
+		seq:           h.seq,
+		duration:      h.elapsed(now),
+		discontinuity: h.discontinuity,
+		data:          h.cur,
+	}
+	h.seq++
+	h.discontinuity = false
+	h.segments = append(h.segments, s)
+	var dropped []*segment
+	if over := len(h.segments) - h.window - keepSegments; over > 0 {
+		dropped = h.segments[:over]
+		for _, d := range dropped {
+			if d.discontinuity {
+				h.discontinuitySeq++
+			}
+		}
+		h.segments = append([]*segment(nil), h.segments[over:]...)
+	}
+	if h.dir != "" {
+		return h.writeDir(s, dropped)
+	}
+	return nil
+}
+
+func (h *hlsoutput) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.closed = true
+	return nil
+}
+
+// ParseHlsOutput sets up an hls output, hls:///dir writes the playlist and
+// segments to dir, hls:// without a path serves them over http
+func ParseHlsOutput(ctx context.Context, u *url.URL, identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up hls output: %s", u.String())
+	h := &hlsoutput{
+		name:       u.String(),
+		identifier: identifier,
+		dir:        u.Path,
+		target:     DefaultSegmentSeconds * time.Second,
+		window:     DefaultWindow,
+		splitter:   tsanalyzer.NewSplitter(),
+		state:      output.NewStateTracker(output.StateActive),
+		//media sequence numbers keep increasing when the output restarts
+		seq: int(time.Now().Unix()),
+	}
+	if v := u.Query().Get("segment"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 1 {
+			return nil, fmt.Errorf("segment must be a positive number of seconds: %s", v)
+		}
+		h.target = time.Duration(secs) * time.Second
+	}
+	if v := u.Query().Get("window"); v != "" {
+		window, err := strconv.Atoi(v)
+		if err != nil || window < 1 {
+			return nil, fmt.Errorf("window must be a positive number of segments: %s", v)
+		}
+		h.window = window
+	}
+	if h.dir != "" {
+		if err := h.cleanDir(); err != nil {
+			return nil, err
+		}
+	}
+	m.AddOutput(h, opts)
+	return h, nil
+}
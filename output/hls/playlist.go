@@ -0,0 +1,178 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	playlistName  = "index.m3u8"
+	segmentPrefix = "segment-"
+	segmentExt    = ".ts"
+)
+
+func segmentName(seq int) string {
+	return segmentPrefix + strconv.Itoa(seq) + segmentExt
+}
+
+// playlist renders the live playlist of the last window segments, lock must
+// be held
+func (h *hlsoutput) playlist() []byte {
+	listed := h.segments
+	discontinuitySeq := h.discontinuitySeq
+	if len(listed) > h.window {
+		for _, s := range listed[:len(listed)-h.window] {
+			if s.discontinuity {
+				discontinuitySeq++
+			}
+		}
+		listed = listed[len(listed)-h.window:]
+	}
+	target := int(h.target / time.Second)
+	for _, s := range listed {
+		if d := int(math.Ceil(s.duration.Seconds())); d > target {
+			target = d
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	if len(listed) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", listed[0].seq)
+	}
+	if discontinuitySeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySeq)
+	}
+	for _, s := range listed {
+		if s.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), segmentName(s.seq))
+	}
+	return b.Bytes()
+}
+
+// ServeHTTP serves index.m3u8 and the segments of an output without
+// directory, the last element of the request path is the file requested
+func (h *hlsoutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Base(r.URL.Path)
+	h.lock.Lock()
+	if h.dir != "" {
+		h.lock.Unlock()
+		http.Error(w, "hls output is written to a directory", http.StatusNotFound)
+		return
+	}
+	if len(h.segments) == 0 {
+		h.lock.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.target/time.Second)))
+		http.Error(w, "no segments yet", http.StatusServiceUnavailable)
+		return
+	}
+	var (
+		data        []byte
+		contentType string
+	)
+	if name == playlistName {
+		data = h.playlist()
+		contentType = "application/vnd.apple.mpegurl"
+		w.Header().Set("Cache-Control", "no-cache")
+	} else if seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentExt)); err == nil {
+		for _, s := range h.segments {
+			if s.seq == seq {
+				//finished segments are not modified
+				data = s.data
+				break
+			}
+		}
+		contentType = "video/mp2t"
+	}
+	h.lock.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+// writeFile replaces name in the output directory
+func (h *hlsoutput) writeFile(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(h.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(h.dir, name))
+}
+
+// writeDir writes segment s and the playlist, and removes the dropped
+// segments, lock must be held
+func (h *hlsoutput) writeDir(s *segment, dropped []*segment) error {
+	if err := h.writeFile(segmentName(s.seq), s.data); err != nil {
+		return err
+	}
+	//the data is on disk, only the meta data is kept
+	s.data = nil
+	if err := h.writeFile(playlistName, h.playlist()); err != nil {
+		return err
+	}
+	for _, d := range dropped {
+		if err := os.Remove(filepath.Join(h.dir, segmentName(d.seq))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanDir removes the playlist and segments of a previous run
+func (h *hlsoutput) cleanDir() error {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if name != playlistName && !(strings.HasPrefix(name, segmentPrefix) && strings.HasSuffix(name, segmentExt)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
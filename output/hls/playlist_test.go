@@ -0,0 +1,89 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/odmedia/streamzeug/tsanalyzer"
+)
+
+func TestPlaylistWindow(t *testing.T) {
+	tests := []struct {
+		name string
+		//the discontinuity flag of each segment finished
+		segments []bool
+		want     string
+	}{
+		{
+			name:     "filling",
+			segments: []bool{false, false},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+				"#EXTINF:6.000,\nsegment-0.ts\n" +
+				"#EXTINF:6.000,\nsegment-1.ts\n",
+		},
+		{
+			name:     "sliding",
+			segments: []bool{false, false, false, false, false},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:2\n" +
+				"#EXTINF:6.000,\nsegment-2.ts\n" +
+				"#EXTINF:6.000,\nsegment-3.ts\n" +
+				"#EXTINF:6.000,\nsegment-4.ts\n",
+		},
+		{
+			name:     "discontinuity in window",
+			segments: []bool{false, true, false},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+				"#EXTINF:6.000,\nsegment-0.ts\n" +
+				"#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\nsegment-1.ts\n" +
+				"#EXTINF:6.000,\nsegment-2.ts\n",
+		},
+		{
+			name:     "discontinuity left the window",
+			segments: []bool{false, true, false, false, false, false},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:3\n" +
+				"#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+				"#EXTINF:6.000,\nsegment-3.ts\n" +
+				"#EXTINF:6.000,\nsegment-4.ts\n" +
+				"#EXTINF:6.000,\nsegment-5.ts\n",
+		},
+		{
+			name:     "discontinuity dropped",
+			segments: []bool{false, true, false, false, false, false, true, false},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:5\n" +
+				"#EXT-X-DISCONTINUITY-SEQUENCE:1\n" +
+				"#EXTINF:6.000,\nsegment-5.ts\n" +
+				"#EXT-X-DISCONTINUITY\n#EXTINF:6.000,\nsegment-6.ts\n" +
+				"#EXTINF:6.000,\nsegment-7.ts\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &hlsoutput{
+				target:   6 * time.Second,
+				window:   3,
+				splitter: tsanalyzer.NewSplitter(),
+			}
+			now := time.Now()
+			for _, discontinuity := range tt.segments {
+				h.curStart = now
+				now = now.Add(6 * time.Second)
+				h.discontinuity = discontinuity
+				if err := h.finish(now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(h.segments) > h.window+keepSegments {
+				t.Fatalf("%d segments kept, expected at most %d", len(h.segments), h.window+keepSegments)
+			}
+			if got := string(h.playlist()); got != tt.want {
+				t.Fatalf("expected playlist:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package httpts
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/output"
+	"github.com/odmedia/streamzeug/output/clientstats"
+	"github.com/odmedia/streamzeug/stats"
+)
+
+const (
+	DefaultMaxClients = 10
+	// blocks queued per client, a client falling this far behind is
+	// disconnected
+	DefaultQueue = 1024
+	//a client not accepting data for this long is disconnected
+	clientWriteTimeout = 5 * time.Second
+)
+
+type httpclient struct {
+	addr  string
+	host  string
+	since time.Time
+	ch    chan []byte
+	//closed when the client is evicted
+	evicted chan struct{}
+	bytes   int64
+	blocks  int64
+}
+
+// httptsoutput serves the transport stream to http clients, each client has
+// its own queue so a slow client never blocks the flow or other clients
+type httptsoutput struct {
+	ctx               context.Context
+	cancel            context.CancelFunc
+	name              string
+	identifier        string
+	output_identifier string
+	maxClients        int
+	queue             int
+	stats             *stats.Stats
+	state             *output.StateTracker
+	lock              sync.Mutex
+	clients           map[*httpclient]struct{}
+}
+
+func (h *httptsoutput) String() string {
+	return h.name
+}
+
+func (h *httptsoutput) Count() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.clients)
+}
+
+func (h *httptsoutput) Status() output.Status {
+	status := h.state.Status()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	status.Clients = make([]string, 0, len(h.clients))
+	for c := range h.clients {
+		status.Clients = append(status.Clients, c.addr)
+	}
+	sort.Strings(status.Clients)
+	return status
+}
+
+func (h *httptsoutput) Write(block *block.Block) (n int, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.clients) == 0 {
+		return len(block.Data), nil
+	}
+	//the block is returned to the pool after Write
+	data := append([]byte(nil), block.Data...)
+	for c := range h.clients {
+		select {
+		case c.ch <- data:
+		default:
+			logging.Log.Warn().Str("identifier", h.identifier).Str("output_identifier", h.output_identifier).Str("client", c.addr).Int("queue", h.queue).Msg("http client too slow, disconnecting")
+			h.state.Error(fmt.Errorf("client %s too slow, disconnected", c.addr))
+			delete(h.clients, c)
+			close(c.evicted)
+		}
+	}
+	return len(block.Data), nil
+}
+
+func (h *httptsoutput) Close() error {
+	h.cancel()
+	return nil
+}
+
+func (h *httptsoutput) removeClient(c *httpclient) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.clients, c)
+}
+
+type connContextKey struct{}
+
+// ConnContext stores the connection of a request in its context, so
+// ServeHTTP can enforce a write timeout. It is to be set as the ConnContext
+// of the http server serving the outputs.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ServeHTTP streams the flow as a chunked response until the client
+// disconnects, falls behind or the output is closed. Writes to a stalled
+// client time out when the server sets ConnContext, otherwise the client is
+// only evicted from the queue.
+func (h *httptsoutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := &httpclient{
+		addr:    r.RemoteAddr,
+		host:    r.RemoteAddr,
+		since:   time.Now(),
+		ch:      make(chan []byte, h.queue),
+		evicted: make(chan struct{}),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		c.host = host
+	}
+	h.lock.Lock()
+	if h.ctx.Err() != nil {
+		h.lock.Unlock()
+		http.Error(w, "output closed", http.StatusServiceUnavailable)
+		return
+	}
+	if len(h.clients) >= h.maxClients {
+		h.lock.Unlock()
+		http.Error(w, "too many clients", http.StatusServiceUnavailable)
+		return
+	}
+	h.clients[c] = struct{}{}
+	h.lock.Unlock()
+	defer h.removeClient(c)
+	logging.Log.Info().Str("identifier", h.identifier).Str("output_identifier", h.output_identifier).Str("client", c.addr).Msgf("http client %s connected", c.addr)
+	defer logging.Log.Info().Str("identifier", h.identifier).Str("output_identifier", h.output_identifier).Str("client", c.addr).Msgf("http client %s disconnected", c.addr)
+
+	conn, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	//the deadline is left in place when returning, so ending the response
+	//can't block either, the connection isn't reused
+	extendDeadline := func() {
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		}
+	}
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	go h.statsLoop(ctx, c)
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "close")
+	extendDeadline()
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.Context().Done():
+			return
+		case <-c.evicted:
+			return
+		case data := <-c.ch:
+			extendDeadline()
+			n, err := w.Write(data)
+			atomic.AddInt64(&c.bytes, int64(n))
+			atomic.AddInt64(&c.blocks, 1)
+			if err != nil {
+				return
+			}
+			//write what is queued before flushing
+			if len(c.ch) > 0 {
+				continue
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *httptsoutput) statsLoop(ctx context.Context, c *httpclient) {
+	if h.stats == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	var lastBytes, lastBlocks int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			//
+		}
+		bytes := int(atomic.LoadInt64(&c.bytes))
+		blocks := int(atomic.LoadInt64(&c.blocks))
+		s := &clientstats.ClientStats{
+			ConnectedMS: int(time.Since(c.since).Milliseconds()),
+			BytesTotal:  bytes,
+			Bytes:       bytes - lastBytes,
+			BlocksTotal: blocks,
+			Blocks:      blocks - lastBlocks,
+			Queued:      len(c.ch),
+		}
+		lastBytes, lastBlocks = bytes, blocks
+		go h.stats.HandleStats(c.host, h.output_identifier, nil, s)
+	}
+}
+
+// ParseHttpTsOutput sets up an output served on /flows/<identifier>/stream.ts,
+// httpts://?maxclients=10&queue=1024
+func ParseHttpTsOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions, stats *stats.Stats) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up http ts output: %s", u.String())
+	h := &httptsoutput{
+		name:              u.String(),
+		identifier:        identifier,
+		output_identifier: output_identifier,
+		maxClients:        DefaultMaxClients,
+		queue:             DefaultQueue,
+		stats:             stats,
+		state:             output.NewStateTracker(output.StateListening),
+		clients:           make(map[*httpclient]struct{}),
+	}
+	for param, v := range map[string]*int{"maxclients": &h.maxClients, "queue": &h.queue} {
+		s := u.Query().Get(param)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s must be a positive number: %s", param, s)
+		}
+		*v = n
+	}
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	m.AddOutput(h, opts)
+	return h, nil
+}
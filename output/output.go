@@ -6,11 +6,14 @@
 
 package output
 
-import "code.videolan.org/rist/ristgo/libristwrapper"
+import "github.com/odmedia/streamzeug/block"
 
+// Output receives the blocks of a flow from the mainloop. Write must not hold
+// on to the block or its data after returning, the mainloop returns the
+// reference when Write is done.
 type Output interface {
 	Close() error
-	Write(block *libristwrapper.RistDataBlock) (n int, err error)
+	Write(block *block.Block) (n int, err error)
 	String() string
 	Count() int
 }
@@ -8,39 +8,253 @@ package rist
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"code.videolan.org/rist/ristgo"
 	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/output"
 	"github.com/odmedia/streamzeug/stats"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultBufferSize = 1000
+	reconnectInterval = 1 * time.Second
 )
 
 type ristoutput struct {
-	sender    ristgo.Sender
-	clientUrl string
+	ctx               context.Context
+	cancel            context.CancelFunc
+	sender            ristgo.Sender
+	identifier        string
+	output_identifier string
+	profile           libristwrapper.RistProfile
+	buffersize        int
+	peers             []*url.URL
+	clientUrl         string
+	m                 *mainloop.Mainloop
+	opts              mainloop.OutputOptions
+	stats             *stats.Stats
+	logger            zerolog.Logger
+	reconnecting      sync.Mutex
+	//guards sender, which is replaced while reconnecting
+	senderLock sync.Mutex
+	state      *output.StateTracker
+}
+
+func createStatsCB(r *ristoutput) libristwrapper.StatsCallbackFunc {
+	//the stats of multiple peers are told apart by their cname
+	var u *url.URL
+	if len(r.peers) == 1 {
+		u = r.peers[0]
+	}
+	return func(stats *libristwrapper.StatsContainer) {
+		if stats.SenderStats != nil {
+			r.stats.HandleStats("", r.output_identifier, u, stats.SenderStats)
+		}
+	}
 }
 
-func ParseRistOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, stats *stats.Stats, wait *sync.WaitGroup) (output.Output, error) {
+func createLogCB(logger zerolog.Logger) libristwrapper.LogCallbackFunc {
+	return func(loglevel libristwrapper.RistLogLevel, logmessage string) {
+		logmessage = strings.TrimSuffix(logmessage, "\n")
+		switch loglevel {
+		case libristwrapper.LogLevelError:
+			logger.Error().Msg(logmessage)
+		case libristwrapper.LogLevelWarn:
+			logger.Warn().Msg(logmessage)
+		case libristwrapper.LogLevelNotice:
+			logger.Info().Msg(logmessage)
+		case libristwrapper.LogLevelInfo:
+			logger.Info().Msg(logmessage)
+		case libristwrapper.LogLevelDebug:
+			logger.Debug().Msg(logmessage)
+		}
+	}
+}
 
-	return &ristoutput{}, nil
+func parseProfile(p string) (libristwrapper.RistProfile, error) {
+	switch strings.ToLower(p) {
+	case "", "0", "simple":
+		return libristwrapper.RistProfileSimple, nil
+	case "1", "main":
+		return libristwrapper.RistProfileMain, nil
+	}
+	return 0, fmt.Errorf("invalid rist profile: %s", p)
+}
+
+// stripOptions removes the streamzeug specific options from the url, so it can
+// be handed to librist as a peer url
+func stripOptions(u *url.URL) *url.URL {
+	stripped, _ := url.Parse(u.String())
+	q := stripped.Query()
+	q.Del("identifier")
+	q.Del("profile")
+	q.Del("latency")
+	stripped.RawQuery = q.Encode()
+	return stripped
+}
+
+func sanitise(u *url.URL) string {
+	if u.Query().Get("secret") == "" {
+		return u.String()
+	}
+	sanitised, _ := url.Parse(u.String())
+	q := sanitised.Query()
+	q.Set("secret", "REDACTED")
+	sanitised.RawQuery = q.Encode()
+	return sanitised.String()
+}
+
+func (r *ristoutput) setupSender() error {
+	sender, err := ristgo.SenderCreate(r.ctx, &ristgo.SenderConfig{
+		RistProfile:             r.profile,
+		LoggingCallbackFunction: createLogCB(r.logger),
+		StatsCallbackFunction:   createStatsCB(r),
+		StatsInterval:           stats.StatsIntervalSeconds * 1000,
+		RecoveryBufferSize:      r.buffersize,
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range r.peers {
+		peerConfig, err := ristgo.ParseRistURL(stripOptions(p))
+		if err != nil {
+			sender.Close()
+			return err
+		}
+		if _, err := sender.AddPeer(peerConfig); err != nil {
+			sender.Close()
+			return fmt.Errorf("failed to add peer %s: %w", sanitise(p), err)
+		}
+	}
+	if err := sender.Start(); err != nil {
+		sender.Close()
+		return err
+	}
+	r.senderLock.Lock()
+	r.sender = sender
+	r.senderLock.Unlock()
+	return nil
+}
+
+// closeSender closes and clears the sender, if any
+func (r *ristoutput) closeSender() {
+	r.senderLock.Lock()
+	defer r.senderLock.Unlock()
+	if r.sender != nil {
+		r.sender.Close()
+		r.sender = nil
+	}
+}
+
+func (r *ristoutput) reconnect() {
+	r.reconnecting.Lock()
+	defer r.reconnecting.Unlock()
+	r.closeSender()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+			//
+		}
+		err := r.setupSender()
+		if err != nil {
+			r.logger.Error().Err(err).Msgf("failed to re-create rist sender for %s", r.clientUrl)
+			r.state.Error(err)
+			continue
+		}
+		r.logger.Info().Msgf("rist sender for %s re-created", r.clientUrl)
+		r.state.Set(output.StateActive)
+		r.m.AddOutput(r, r.opts)
+		return
+	}
+}
+
+func ParseRistOutput(ctx context.Context, u *url.URL, peers []*url.URL, identifier, output_identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions, stats *stats.Stats) (output.Output, error) {
+	var err error
+	r := &ristoutput{
+		identifier:        identifier,
+		output_identifier: output_identifier,
+		peers:             append([]*url.URL{u}, peers...),
+		m:                 m,
+		opts:              opts,
+		stats:             stats,
+		buffersize:        defaultBufferSize,
+		state:             output.NewStateTracker(output.StateActive),
+		logger:            logging.Log.With().Str("module", "rist-output").Str("identifier", identifier).Str("output_identifier", output_identifier).Logger(),
+	}
+	names := make([]string, 0, len(r.peers))
+	for _, p := range r.peers {
+		names = append(names, sanitise(p))
+	}
+	r.clientUrl = "rist: " + strings.Join(names, ",")
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up rist output: %s", r.clientUrl)
+
+	r.profile, err = parseProfile(u.Query().Get("profile"))
+	if err != nil {
+		return nil, err
+	}
+	if latency := u.Query().Get("latency"); latency != "" {
+		if r.buffersize, err = strconv.Atoi(latency); err != nil {
+			return nil, err
+		}
+		if r.buffersize <= 0 {
+			return nil, errors.New("latency must be larger than 0")
+		}
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	if err := r.setupSender(); err != nil {
+		r.cancel()
+		return nil, err
+	}
+	r.m.AddOutput(r, r.opts)
+	return r, nil
 }
 
 func (r *ristoutput) Close() error {
-	r.sender.Close()
+	r.cancel()
+	r.reconnecting.Lock()
+	defer r.reconnecting.Unlock()
+	r.closeSender()
 	return nil
 }
 
 func (r *ristoutput) Count() int {
-	return 1
+	return len(r.peers)
 }
 
 func (r *ristoutput) String() string {
 	return r.clientUrl
 }
 
-func (r *ristoutput) Write(block *libristwrapper.RistDataBlock) (n int, e error) {
-	return r.sender.Write(block.Data)
+func (r *ristoutput) Status() output.Status {
+	return r.state.Status()
+}
+
+func (r *ristoutput) Write(block *block.Block) (n int, e error) {
+	r.senderLock.Lock()
+	if r.sender == nil {
+		//closed or reconnecting, a block queued before that is dropped
+		r.senderLock.Unlock()
+		return 0, errors.New("rist sender closed")
+	}
+	n, e = r.sender.Write(block.Data)
+	r.senderLock.Unlock()
+	if e != nil {
+		r.logger.Error().Err(e).Msgf("lost rist sender for %s, reconnecting", r.clientUrl)
+		r.state.Failed(output.StateReconnecting, e)
+		go r.reconnect()
+	}
+	return
 }
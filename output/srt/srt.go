@@ -10,13 +10,14 @@ import (
 	"context"
 	"errors"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/haivision/srtgo"
+	"github.com/odmedia/streamzeug/block"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/output"
@@ -46,12 +47,15 @@ type srtoutput struct {
 	Url               *url.URL
 	SanitisedURL      *url.URL
 	m                 *mainloop.Mainloop
+	opts              mainloop.OutputOptions
 	stats             *stats.Stats
 	wg                *sync.WaitGroup
 	parent            *srtoutput
 	index             int
 	clientsLock       *sync.Mutex
 	clients           map[int]*srtoutput
+	addr              string
+	state             *output.StateTracker
 }
 
 func (s *srtoutput) String() string {
@@ -68,7 +72,22 @@ func (s *srtoutput) Count() int {
 	return len(s.clients)
 }
 
-func (s *srtoutput) Write(block *libristwrapper.RistDataBlock) (n int, e error) {
+func (s *srtoutput) Status() output.Status {
+	status := s.state.Status()
+	if s.clientsLock == nil {
+		return status
+	}
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	status.Clients = make([]string, 0, len(s.clients))
+	for _, c := range s.clients {
+		status.Clients = append(status.Clients, c.addr)
+	}
+	sort.Strings(status.Clients)
+	return status
+}
+
+func (s *srtoutput) Write(block *block.Block) (n int, e error) {
 	n, e = s.srt.Write(block.Data)
 	if e != nil {
 		if s.srt.Mode() == srtgo.ModeFailure {
@@ -76,8 +95,10 @@ func (s *srtoutput) Write(block *libristwrapper.RistDataBlock) (n int, e error)
 			s.parent.clientsLock.Lock()
 			delete(s.parent.clients, s.index)
 			s.parent.clientsLock.Unlock()
+			s.parent.state.Error(e)
 		} else if s.srt.Mode() == srtgo.ModeCaller {
 			logger.Info().Str("identifier", s.identifier).Str("output_identifier", s.output_identifier).Str("srt-url", s.SanitisedURL.String()).Str("client", s.host).Msgf("Lost connection to SRT server: %s", s.host)
+			s.state.Failed(output.StateReconnecting, e)
 			s.srt.Close()
 			go s.reconnect()
 		}
@@ -94,25 +115,26 @@ func (s *srtoutput) Close() error {
 }
 
 func (s *srtoutput) listenAccept() {
-	s.clients = make(map[int]*srtoutput, 5)
-	s.clientsLock = new(sync.Mutex)
 	clientIndex := 0
 	for {
 		srtSocket, u, err := s.srt.Accept()
 		if err != nil {
 			logger.Error().Str("identifier", s.identifier).Str("output_identifier", s.output_identifier).Str("srt-url", s.SanitisedURL.String()).Err(err).Msg("error in srtsocket listen")
+			s.state.Failed(output.StateFailed, err)
 			break
 		}
 		srtoutput := *s
 		srtoutput.srt = srtSocket
 		srtoutput.parent = s
 		srtoutput.host = u.IP.String()
+		srtoutput.addr = u.String()
+		srtoutput.state = output.NewStateTracker(output.StateActive)
 		srtoutput.index = clientIndex
 		s.clientsLock.Lock()
 		s.clients[clientIndex] = &srtoutput
 		s.clientsLock.Unlock()
 		clientIndex++
-		s.m.AddOutput(&srtoutput)
+		s.m.AddOutput(&srtoutput, s.opts)
 		go srtoutput.statsLoop()
 	}
 
@@ -148,6 +170,7 @@ func (s *srtoutput) reconnect() {
 	}
 	if err := setupSrtSocket(s); err != nil {
 		logging.Log.Error().Err(err).Msg("this should be impossible in reconnect loop")
+		s.state.Failed(output.StateFailed, err)
 	}
 }
 
@@ -180,11 +203,15 @@ func setupSrtSocket(s *srtoutput) error {
 		if err := srtSocket.Listen(5); err != nil {
 			return err
 		}
+		s.state.Set(output.StateListening)
+		s.clients = make(map[int]*srtoutput, 5)
+		s.clientsLock = new(sync.Mutex)
 		s.wg.Add(1)
 		go s.listenAccept()
 	} else {
 		if err := srtSocket.Connect(); err != nil {
 			if _, ok := err.(*srtgo.SrtSocketClosed); ok {
+				s.state.Failed(output.StateReconnecting, err)
 				srtSocket.Close()
 				go s.reconnect()
 				return nil
@@ -192,13 +219,14 @@ func setupSrtSocket(s *srtoutput) error {
 			return err
 		}
 		logger.Info().Str("output_identifier", s.identifier).Str("srt-url", s.Url.String()).Str("client", s.host).Msgf("SRT Connected to: %s", s.host)
+		s.state.Set(output.StateActive)
 		go s.statsLoop()
-		s.m.AddOutput(s)
+		s.m.AddOutput(s, s.opts)
 	}
 	return nil
 }
 
-func ParseSrtOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, stats *stats.Stats, wait *sync.WaitGroup) (output.Output, error) {
+func ParseSrtOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions, stats *stats.Stats, wait *sync.WaitGroup) (output.Output, error) {
 	context, cancel := context.WithCancel(ctx)
 	var srtout srtoutput
 	srtout.Url = u
@@ -217,8 +245,10 @@ func ParseSrtOutput(ctx context.Context, u *url.URL, identifier, output_identifi
 	srtout.cancel = cancel
 	srtout.timeout = 0
 	srtout.m = m
+	srtout.opts = opts
 	srtout.stats = stats
 	srtout.wg = wait
+	srtout.state = output.NewStateTracker(output.StateActive)
 	err := setupSrtSocket(&srtout)
 	if err != nil {
 		return nil, err
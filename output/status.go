@@ -0,0 +1,81 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package output
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateActive           = "active"
+	StateFloatingInactive = "floating-inactive"
+	StateReconnecting     = "reconnecting"
+	StateListening        = "listening"
+	StateFailed           = "failed"
+)
+
+type Status struct {
+	State     string    `json:"state"`
+	Since     time.Time `json:"statesince"`
+	MsInState int       `json:"msinstate"`
+	LastError string    `json:"lasterror,omitempty"`
+	// connected clients of listening outputs
+	Clients []string `json:"clients,omitempty"`
+}
+
+// StatusReporter is implemented by outputs that track their own state
+type StatusReporter interface {
+	Status() Status
+}
+
+// StateTracker keeps the state of an output, it is safe for concurrent use
+type StateTracker struct {
+	lock   sync.Mutex
+	status Status
+}
+
+func NewStateTracker(state string) *StateTracker {
+	return &StateTracker{
+		status: Status{
+			State: state,
+			Since: time.Now(),
+		},
+	}
+}
+
+// Set changes the state, the time in state is only reset when it differs
+func (t *StateTracker) Set(state string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.status.State == state {
+		return
+	}
+	t.status.State = state
+	t.status.Since = time.Now()
+}
+
+// Error records err as the last error
+func (t *StateTracker) Error(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.LastError = err.Error()
+}
+
+// Failed records err and changes the state
+func (t *StateTracker) Failed(state string, err error) {
+	t.Error(err)
+	t.Set(state)
+}
+
+func (t *StateTracker) Status() Status {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status := t.status
+	status.MsInState = int(time.Since(status.Since).Milliseconds())
+	return status
+}
@@ -0,0 +1,287 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tcp
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop"
+	"github.com/odmedia/streamzeug/output"
+	"github.com/odmedia/streamzeug/output/clientstats"
+	"github.com/odmedia/streamzeug/stats"
+)
+
+const (
+	connectTimeout    = 5 * time.Second
+	reconnectInterval = time.Second
+	//a peer not accepting data for this long is disconnected
+	writeTimeout = 5 * time.Second
+)
+
+// tcpoutput is a caller connection, a listener or a client connected to a
+// listener. Like the srt output every connection is a separate mainloop
+// output with its own queue.
+type tcpoutput struct {
+	ctx               context.Context
+	cancel            context.CancelFunc
+	conn              net.Conn
+	listener          net.Listener
+	host              string
+	addr              string
+	identifier        string
+	output_identifier string
+	Url               *url.URL
+	m                 *mainloop.Mainloop
+	opts              mainloop.OutputOptions
+	stats             *stats.Stats
+	wg                *sync.WaitGroup
+	parent            *tcpoutput
+	index             int
+	clientsLock       *sync.Mutex
+	clients           map[int]*tcpoutput
+	state             *output.StateTracker
+	//cancels the stats of the current caller connection
+	connCancel context.CancelFunc
+	since      time.Time
+	bytes      int64
+	blocks     int64
+}
+
+func (t *tcpoutput) String() string {
+	if t.parent == nil {
+		return "tcp: " + t.Url.String()
+	}
+	return "tcp: " + t.host + "@" + t.Url.String()
+}
+
+func (t *tcpoutput) Count() int {
+	if t.listener == nil {
+		return 1
+	}
+	t.clientsLock.Lock()
+	defer t.clientsLock.Unlock()
+	return len(t.clients)
+}
+
+func (t *tcpoutput) Status() output.Status {
+	status := t.state.Status()
+	if t.clientsLock == nil {
+		return status
+	}
+	t.clientsLock.Lock()
+	defer t.clientsLock.Unlock()
+	status.Clients = make([]string, 0, len(t.clients))
+	for _, c := range t.clients {
+		status.Clients = append(status.Clients, c.addr)
+	}
+	sort.Strings(status.Clients)
+	return status
+}
+
+func (t *tcpoutput) Write(block *block.Block) (n int, e error) {
+	t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	n, e = t.conn.Write(block.Data)
+	atomic.AddInt64(&t.bytes, int64(n))
+	atomic.AddInt64(&t.blocks, 1)
+	if e == nil {
+		return
+	}
+	if t.parent != nil {
+		logging.Log.Info().Str("identifier", t.identifier).Str("output_identifier", t.output_identifier).Str("client", t.addr).Err(e).Msgf("TCP client %s disconnected", t.addr)
+		t.parent.clientsLock.Lock()
+		delete(t.parent.clients, t.index)
+		t.parent.clientsLock.Unlock()
+		t.parent.state.Error(e)
+		t.Close()
+		return
+	}
+	logging.Log.Info().Str("identifier", t.identifier).Str("output_identifier", t.output_identifier).Str("client", t.host).Err(e).Msgf("Lost connection to TCP server: %s", t.host)
+	t.state.Failed(output.StateReconnecting, e)
+	t.connCancel()
+	t.conn.Close()
+	go t.reconnect()
+	return
+}
+
+func (t *tcpoutput) Close() error {
+	t.cancel()
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	return nil
+}
+
+func (t *tcpoutput) listenAccept() {
+	clientIndex := 0
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if t.ctx.Err() == nil {
+				logging.Log.Error().Str("identifier", t.identifier).Str("output_identifier", t.output_identifier).Str("tcp-url", t.Url.String()).Err(err).Msg("error in tcp listen")
+				t.state.Failed(output.StateFailed, err)
+			}
+			break
+		}
+		client := &tcpoutput{
+			conn:              conn,
+			identifier:        t.identifier,
+			output_identifier: t.output_identifier,
+			Url:               t.Url,
+			m:                 t.m,
+			stats:             t.stats,
+			parent:            t,
+			index:             clientIndex,
+			addr:              conn.RemoteAddr().String(),
+			host:              conn.RemoteAddr().String(),
+			state:             output.NewStateTracker(output.StateActive),
+			since:             time.Now(),
+		}
+		if host, _, err := net.SplitHostPort(client.addr); err == nil {
+			client.host = host
+		}
+		client.ctx, client.cancel = context.WithCancel(t.ctx)
+		logging.Log.Info().Str("identifier", t.identifier).Str("output_identifier", t.output_identifier).Str("client", client.addr).Msgf("TCP client %s connected", client.addr)
+		t.clientsLock.Lock()
+		t.clients[clientIndex] = client
+		t.clientsLock.Unlock()
+		clientIndex++
+		t.m.AddOutput(client, t.opts)
+		go client.statsLoop(client.ctx)
+	}
+
+	t.clientsLock.Lock()
+	for _, c := range t.clients {
+		c.Close()
+	}
+	t.clientsLock.Unlock()
+	t.wg.Done()
+}
+
+// statsLoop reports the stats of the connection until ctx is done
+func (t *tcpoutput) statsLoop(ctx context.Context) {
+	if t.stats == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	var lastBytes, lastBlocks int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			//
+		}
+		bytes := int(atomic.LoadInt64(&t.bytes))
+		blocks := int(atomic.LoadInt64(&t.blocks))
+		s := &clientstats.ClientStats{
+			ConnectedMS: int(time.Since(t.since).Milliseconds()),
+			BytesTotal:  bytes,
+			Bytes:       bytes - lastBytes,
+			BlocksTotal: blocks,
+			Blocks:      blocks - lastBlocks,
+		}
+		lastBytes, lastBlocks = bytes, blocks
+		go t.stats.HandleStats(t.host, t.output_identifier, t.Url, s)
+	}
+}
+
+func (t *tcpoutput) connect() error {
+	conn, err := net.DialTimeout("tcp", t.Url.Host, connectTimeout)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	t.since = time.Now()
+	atomic.StoreInt64(&t.bytes, 0)
+	atomic.StoreInt64(&t.blocks, 0)
+	logging.Log.Info().Str("identifier", t.identifier).Str("output_identifier", t.output_identifier).Str("tcp-url", t.Url.String()).Str("client", t.host).Msgf("TCP Connected to: %s", t.host)
+	t.state.Set(output.StateActive)
+	var ctx context.Context
+	ctx, t.connCancel = context.WithCancel(t.ctx)
+	go t.statsLoop(ctx)
+	t.m.AddOutput(t, t.opts)
+	return nil
+}
+
+// reconnect retries connecting the caller until it succeeds or the output is
+// closed
+func (t *tcpoutput) reconnect() {
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-time.After(reconnectInterval):
+			//
+		}
+		if err := t.connect(); err != nil {
+			t.state.Error(err)
+			continue
+		}
+		return
+	}
+}
+
+// isListener returns whether u is a tcp://0.0.0.0:port (or tcp://:port)
+// listener url
+func isListener(u *url.URL) bool {
+	switch u.Hostname() {
+	case "", "0.0.0.0", "::":
+		return true
+	}
+	return u.Query().Get("mode") == "listener"
+}
+
+// ParseTcpOutput sets up tcp://host:port, connecting to host and
+// reconnecting when the connection is lost, or tcp://0.0.0.0:port, accepting
+// any number of clients
+func ParseTcpOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions, stats *stats.Stats, wait *sync.WaitGroup) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up tcp output: %s", u)
+	t := &tcpoutput{
+		host:              u.Hostname(),
+		identifier:        identifier,
+		output_identifier: output_identifier,
+		Url:               u,
+		m:                 m,
+		opts:              opts,
+		stats:             stats,
+		wg:                wait,
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	if isListener(u) {
+		listener, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			t.cancel()
+			return nil, err
+		}
+		t.listener = listener
+		t.state = output.NewStateTracker(output.StateListening)
+		t.clients = make(map[int]*tcpoutput, 5)
+		t.clientsLock = new(sync.Mutex)
+		t.wg.Add(1)
+		go t.listenAccept()
+		return t, nil
+	}
+	t.state = output.NewStateTracker(output.StateActive)
+	if err := t.connect(); err != nil {
+		logging.Log.Warn().Str("identifier", identifier).Str("output_identifier", output_identifier).Err(err).Msgf("couldn't connect to TCP server: %s, retrying", t.host)
+		t.state.Failed(output.StateReconnecting, err)
+		go t.reconnect()
+	}
+	return t, nil
+}
@@ -17,7 +17,8 @@ import (
 	"syscall"
 	"time"
 
-	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/odmedia/streamzeug/block"
+	"github.com/odmedia/streamzeug/fec"
 	"github.com/odmedia/streamzeug/logging"
 	"github.com/odmedia/streamzeug/mainloop"
 	"github.com/odmedia/streamzeug/output"
@@ -30,6 +31,7 @@ type socketOptFunc func(sc syscall.RawConn) error
 type udpoutput struct {
 	c          *net.UDPConn
 	m          *mainloop.Mainloop
+	opts       mainloop.OutputOptions
 	ctx        context.Context
 	cancel     context.CancelFunc
 	float      bool
@@ -41,8 +43,14 @@ type udpoutput struct {
 	rtpSeq     uint16
 	rtpSSRC    uint32
 	rtpHeader  []byte
+	fec        *fec.Encoder
+	fecParams  *fec.Params
+	//column and row FEC are sent on port+2 and port+4
+	columnConn *net.UDPConn
+	rowConn    *net.UDPConn
 	sc         syscall.RawConn
 	ss         []socketOptFunc
+	state      *output.StateTracker
 }
 
 func (u *udpoutput) String() string {
@@ -53,7 +61,11 @@ func (u *udpoutput) Count() int {
 	return 1
 }
 
-func (u *udpoutput) writeRTP(block *libristwrapper.RistDataBlock) (int, error) {
+func (u *udpoutput) Status() output.Status {
+	return u.state.Status()
+}
+
+func (u *udpoutput) writeRTP(block *block.Block) (int, error) {
 	rtptime := (block.TimeStamp * 90000) >> 32
 	u.rtpHeader[0] = 0x80
 	u.rtpHeader[1] = 0x21 & 0x7f //MPEG-TS
@@ -71,10 +83,23 @@ func (u *udpoutput) writeRTP(block *libristwrapper.RistDataBlock) (int, error) {
 	bufs := make([][]byte, 2)
 	bufs[0] = u.rtpHeader
 	bufs[1] = block.Data
-	return vectorio.WritevSC(u.sc, bufs)
+	n, err := vectorio.WritevSC(u.sc, bufs)
+	if err != nil || u.fec == nil {
+		return n, err
+	}
+	column, row := u.fec.Add(u.rtpHeader, block.Data)
+	if column != nil {
+		if _, err = u.columnConn.Write(column); err != nil {
+			return n, err
+		}
+	}
+	if row != nil {
+		_, err = u.rowConn.Write(row)
+	}
+	return n, err
 }
 
-func (u *udpoutput) Write(block *libristwrapper.RistDataBlock) (n int, err error) {
+func (u *udpoutput) Write(block *block.Block) (n int, err error) {
 	if !u.isRtp {
 		n, err = u.c.Write(block.Data)
 	} else {
@@ -87,9 +112,12 @@ func (u *udpoutput) Write(block *libristwrapper.RistDataBlock) (n int, err error
 		}
 		if u.float {
 			logging.Log.Info().Str("identifier", u.identifier).Msgf("floating udp output: %s entered inactive state", u.name)
+			u.state.Failed(output.StateFloatingInactive, err)
 			go func() {
 				go u.connectloop()
 			}()
+		} else {
+			u.state.Failed(output.StateFailed, err)
 		}
 	}
 	return
@@ -97,6 +125,7 @@ func (u *udpoutput) Write(block *libristwrapper.RistDataBlock) (n int, err error
 
 func (u *udpoutput) Close() error {
 	u.cancel()
+	u.closeFEC()
 	if u.c != nil {
 		return u.c.Close()
 	}
@@ -117,37 +146,90 @@ func (u *udpoutput) connectloop() {
 			continue
 		}
 		logging.Log.Info().Str("identifier", u.identifier).Msgf("floating udp output: %s entered active state", u.name)
-		u.m.AddOutput(u)
+		u.state.Set(output.StateActive)
+		u.m.AddOutput(u, u.opts)
 		return
 	}
 }
 
-func (u *udpoutput) connect() (err error) {
-	u.c, err = net.DialUDP("udp", u.source, u.target)
+func (u *udpoutput) closeFEC() {
+	if u.columnConn != nil {
+		u.columnConn.Close()
+		u.columnConn = nil
+	}
+	if u.rowConn != nil {
+		u.rowConn.Close()
+		u.rowConn = nil
+	}
+}
+
+func (u *udpoutput) dial(source, target *net.UDPAddr) (c *net.UDPConn, sc syscall.RawConn, err error) {
+	c, err = net.DialUDP("udp", source, target)
 	if err != nil {
 		return
 	}
-	u.sc, err = u.c.SyscallConn()
+	sc, err = c.SyscallConn()
 	if err != nil {
+		c.Close()
 		return
 	}
 	for _, s := range u.ss {
-		err = s(u.sc)
+		err = s(sc)
 		if err != nil {
+			c.Close()
+			return
+		}
+	}
+	return
+}
+
+// connectFEC sets up the FEC sockets, from the same interface as the media
+// but from any port
+func (u *udpoutput) connectFEC() (err error) {
+	u.closeFEC()
+	var source *net.UDPAddr
+	if u.source != nil {
+		source = &net.UDPAddr{IP: u.source.IP, Zone: u.source.Zone}
+	}
+	columnTarget := &net.UDPAddr{IP: u.target.IP, Port: u.target.Port + fec.ColumnPortOffset, Zone: u.target.Zone}
+	if u.columnConn, _, err = u.dial(source, columnTarget); err != nil {
+		return
+	}
+	if u.fecParams.Rows {
+		rowTarget := &net.UDPAddr{IP: u.target.IP, Port: u.target.Port + fec.RowPortOffset, Zone: u.target.Zone}
+		if u.rowConn, _, err = u.dial(source, rowTarget); err != nil {
+			u.closeFEC()
 			return
 		}
 	}
+	//receivers need complete matrices, start a new one
+	u.fec = fec.NewEncoder(*u.fecParams)
+	return
+}
+
+func (u *udpoutput) connect() (err error) {
+	u.c, u.sc, err = u.dial(u.source, u.target)
+	if err != nil {
+		return
+	}
+	if u.fecParams != nil {
+		if err = u.connectFEC(); err != nil {
+			u.c.Close()
+		}
+	}
 	return
 }
 
-func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainloop.Mainloop) (output.Output, error) {
+func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainloop.Mainloop, opts mainloop.OutputOptions) (output.Output, error) {
 	logging.Log.Info().Str("identifier", identifier).Msgf("setting up udp output: %s", u.String())
 	var out udpoutput
 	out.name = u.String()
 	out.identifier = identifier
 	out.ctx, out.cancel = context.WithCancel(ctx)
 	out.m = m
+	out.opts = opts
 	out.float = false
+	out.state = output.NewStateTracker(output.StateActive)
 	out.ss = make([]socketOptFunc, 0)
 	mcastIface := u.Query().Get("iface")
 	float := u.Query().Get("float")
@@ -158,6 +240,11 @@ func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainl
 		out.isRtp = true
 		out.rtpSSRC = rand.Uint32()
 		out.rtpHeader = make([]byte, 12)
+		fecParams, err := fec.ParseParams(u)
+		if err != nil {
+			return nil, err
+		}
+		out.fecParams = fecParams
 	}
 	ttl := 255
 	ttlVal := u.Query().Get("ttl")
@@ -213,11 +300,12 @@ func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainl
 	err = out.connect()
 	if err != nil {
 		if out.float && (errors.Is(err, error(unix.EADDRNOTAVAIL)) || errors.Is(err, error(unix.ENETUNREACH))) {
+			out.state.Failed(output.StateFloatingInactive, err)
 			go out.connectloop()
 			return &out, nil
 		}
 		return nil, err
 	}
-	out.m.AddOutput(&out)
+	out.m.AddOutput(&out, out.opts)
 	return &out, nil
 }
@@ -0,0 +1,96 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package recording
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const tsPacketSize = 188
+
+// Clip reads the part of a recording between two wall-clock times
+type Clip struct {
+	io.Reader
+	// bytes in the clip
+	Size  int64
+	files []*os.File
+}
+
+// OpenClip returns the recording of prefix in dir from start up to end. The
+// clip is cut at the index marks around start and end, so it is up to a
+// second longer on either side, and starts and ends on packet boundaries.
+// Segments removed while the clip is read stay readable until it is closed.
+func OpenClip(dir, prefix string, start, end time.Time) (*Clip, error) {
+	segments, err := Segments(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	c := &Clip{}
+	var readers []io.Reader
+	for _, s := range segments {
+		if s.Start.After(end) || s.End.Add(markInterval).Before(start) {
+			continue
+		}
+		path := filepath.Join(dir, s.File)
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				//removed by retention
+				continue
+			}
+			c.Close()
+			return nil, err
+		}
+		c.files = append(c.files, f)
+		fi, err := f.Stat()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		from, to := int64(0), fi.Size()
+		if start.After(s.Start) {
+			if from, _, err = markOffsets(path+marksExt, start); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		if _, after, err := markOffsets(path+marksExt, end); err != nil {
+			c.Close()
+			return nil, err
+		} else if after >= 0 && after < to {
+			to = after
+		}
+		//the segment being recorded may end in a partially flushed block
+		from -= from % tsPacketSize
+		to -= to % tsPacketSize
+		if to <= from {
+			continue
+		}
+		readers = append(readers, io.NewSectionReader(f, from, to-from))
+		c.Size += to - from
+	}
+	if len(readers) == 0 {
+		c.Close()
+		return nil, ErrNotRecorded
+	}
+	c.Reader = io.MultiReader(readers...)
+	return c, nil
+}
+
+func (c *Clip) Close() error {
+	var err error
+	for _, f := range c.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	c.files = nil
+	return err
+}
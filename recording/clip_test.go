@@ -0,0 +1,70 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package recording
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOpenClip(t *testing.T) {
+	dir := record(t)
+	defer os.RemoveAll(dir)
+	at := func(ms int) time.Time {
+		return testStart.Add(time.Duration(ms) * time.Millisecond)
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		//first and last block expected in the clip
+		first, last int
+		wantErr     error
+	}{
+		{name: "within a segment", start: at(12300), end: at(13000), first: 120, last: 139},
+		{name: "across segments", start: at(5500), end: at(14200), first: 50, last: 149},
+		{name: "from the start", start: at(-5000), end: at(2500), first: 0, last: 29},
+		{name: "up to the end", start: at(23100), end: at(60000), first: 230, last: testBlocks - 1},
+		{name: "not recorded", start: at(60000), end: at(70000), wantErr: ErrNotRecorded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := OpenClip(dir, testPrefix, tt.start, tt.end)
+			if err != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			data, err := ioutil.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(data)) != c.Size {
+				t.Fatalf("read %d bytes, clip size is %d", len(data), c.Size)
+			}
+			if len(data)%testBlockSize != 0 {
+				t.Fatalf("clip of %d bytes isn't cut on block boundaries", len(data))
+			}
+			next := tt.first
+			for p := 0; p < len(data); p += testBlockSize {
+				if data[p] != 0x47 {
+					t.Fatalf("no sync byte at offset %d", p)
+				}
+				if i := int(data[p+1])<<8 | int(data[p+2]); i != next {
+					t.Fatalf("expected block %d at offset %d, got %d", next, p, i)
+				}
+				next++
+			}
+			if next-1 != tt.last {
+				t.Fatalf("expected the clip to end with block %d, got %d", tt.last, next-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package recording
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	segmentExt = ".ts"
+	marksExt   = ".idx"
+	indexExt   = ".index"
+	nameFormat = "20060102T150405Z"
+)
+
+// ErrNotRecorded is returned by Locate and OpenClip when no segment covers
+// the time
+var ErrNotRecorded = errors.New("time not recorded")
+
+// Segment is a single recorded file
+type Segment struct {
+	File  string    `json:"file"`
+	Start time.Time `json:"start"`
+	// modification time for segments found on disk, zero while recording
+	End  time.Time `json:"end"`
+	Size int64     `json:"size"`
+}
+
+func segmentName(prefix string, start time.Time) string {
+	return prefix + "-" + start.UTC().Format(nameFormat) + segmentExt
+}
+
+// segmentStart returns the start time encoded in the name of a segment of
+// prefix
+func segmentStart(prefix, name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, segmentExt) {
+		return time.Time{}, false
+	}
+	ts := name[len(prefix)+1 : len(name)-len(segmentExt)]
+	start, err := time.Parse(nameFormat, ts)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// Segments returns the segments of prefix in dir, oldest first
+func Segments(dir, prefix string) ([]Segment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []Segment
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		start, ok := segmentStart(prefix, fi.Name())
+		if !ok {
+			continue
+		}
+		segments = append(segments, Segment{
+			File:  fi.Name(),
+			Start: start,
+			End:   fi.ModTime().UTC(),
+			Size:  fi.Size(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+	return segments, nil
+}
+
+// Locate returns the segment of prefix in dir covering t, and the offset in
+// it of the last index mark at or before t. Offsets are at packet boundaries.
+func Locate(dir, prefix string, t time.Time) (Segment, int64, error) {
+	segments, err := Segments(dir, prefix)
+	if err != nil {
+		return Segment{}, 0, err
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		s := segments[i]
+		if t.Before(s.Start) {
+			continue
+		}
+		if t.After(s.End.Add(markInterval)) {
+			break
+		}
+		offset, _, err := markOffsets(filepath.Join(dir, s.File+marksExt), t)
+		return s, offset, err
+	}
+	return Segment{}, 0, ErrNotRecorded
+}
+
+// markOffsets returns the offset of the last mark at or before t in the
+// marks file, 0 when there is none, and the offset of the first mark after
+// t, -1 when there is none
+func markOffsets(marksfile string, t time.Time) (before, after int64, err error) {
+	after = -1
+	f, err := os.Open(marksfile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, -1, nil
+		}
+		return 0, -1, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		mt, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			continue
+		}
+		o, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if mt.After(t) {
+			after = o
+			break
+		}
+		before = o
+	}
+	return before, after, scanner.Err()
+}
+
+// writeIndex replaces the index file of the recording, listing every
+// segment with the time range it covers
+func writeIndex(dir, prefix string, segments []Segment) error {
+	tmp, err := ioutil.TempFile(dir, "."+prefix+indexExt+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintln(w, "#start\tend\tbytes\tfile")
+	for _, s := range segments {
+		end := "-"
+		if !s.End.IsZero() {
+			end = s.End.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", s.Start.UTC().Format(time.RFC3339), end, s.Size, s.File)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, prefix+indexExt))
+}
@@ -0,0 +1,114 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRotate = time.Hour
+	minRotate     = 10 * time.Second
+)
+
+// Options of a Recorder
+type Options struct {
+	Dir string
+	// segment file names start with Prefix, usually the flow identifier
+	Prefix string
+	// segments are rotated on multiples of Rotate, aligned to UTC
+	Rotate time.Duration
+	// finished segments older than MaxAge are removed, 0 keeps them
+	MaxAge time.Duration
+	// the oldest segments are removed while all segments together are larger
+	// than MaxSize bytes, 0 means no limit
+	MaxSize int64
+}
+
+// ParseURL reads the options from a file:///dir?rotate=1h&maxage=720h&maxsize=500G
+// url, Prefix is left empty
+func ParseURL(u *url.URL) (Options, error) {
+	opts := Options{
+		Dir:    u.Path,
+		Rotate: DefaultRotate,
+	}
+	if u.Host != "" {
+		return opts, fmt.Errorf("file url must be absolute (file:///path), got host %s", u.Host)
+	}
+	if opts.Dir == "" {
+		return opts, errors.New("file url has no directory")
+	}
+	q := u.Query()
+	var err error
+	if v := q.Get("rotate"); v != "" {
+		if opts.Rotate, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("rotate: %w", err)
+		}
+	}
+	if v := q.Get("maxage"); v != "" {
+		if opts.MaxAge, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("maxage: %w", err)
+		}
+	}
+	if v := q.Get("maxsize"); v != "" {
+		if opts.MaxSize, err = parseSize(v); err != nil {
+			return opts, fmt.Errorf("maxsize: %w", err)
+		}
+	}
+	return opts, opts.Validate()
+}
+
+// Validate checks the options and that Dir is a directory
+func (o *Options) Validate() error {
+	if o.Rotate < minRotate {
+		return fmt.Errorf("rotate must be at least %s", minRotate)
+	}
+	if o.MaxAge < 0 {
+		return errors.New("maxage must be positive")
+	}
+	if o.MaxSize < 0 {
+		return errors.New("maxsize must be positive")
+	}
+	fi, err := os.Stat(o.Dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", o.Dir)
+	}
+	return nil
+}
+
+// parseSize parses a byte count with an optional K, M, G or T (1024 based)
+// suffix
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,193 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package recording
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/odmedia/streamzeug/logging"
+)
+
+// data is flushed and the offset written to the marks file of the segment
+// every markInterval
+const markInterval = time.Second
+
+// Recorder writes a transport stream to time rotated segment files in a
+// directory. Next to every segment a marks file (.idx) maps wall-clock times
+// to offsets in the segment, and the <prefix>.index file lists all segments.
+// A Recorder is not safe for concurrent use.
+type Recorder struct {
+	opts     Options
+	segments []Segment
+	file     *os.File
+	w        *bufio.Writer
+	marks    *os.File
+	offset   int64
+	rotateAt time.Time
+	nextMark time.Time
+}
+
+// New returns a Recorder continuing the recording already in opts.Dir, the
+// first segment is opened on the first Write
+func New(opts Options) (*Recorder, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	segments, err := Segments(opts.Dir, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		opts:     opts,
+		segments: segments,
+	}
+	r.retention(time.Now())
+	return r, writeIndex(opts.Dir, opts.Prefix, r.segments)
+}
+
+// Write appends data, which must hold whole TS packets, to the segment
+// covering now
+func (r *Recorder) Write(data []byte, now time.Time) error {
+	if r.file == nil || !now.Before(r.rotateAt) {
+		if err := r.rotate(now); err != nil {
+			return err
+		}
+	}
+	if !now.Before(r.nextMark) {
+		if err := r.mark(now); err != nil {
+			return err
+		}
+	}
+	n, err := r.w.Write(data)
+	r.offset += int64(n)
+	return err
+}
+
+// mark flushes the segment and records the current offset
+func (r *Recorder) mark(now time.Time) error {
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(r.marks, "%s %d\n", now.UTC().Format(time.RFC3339Nano), r.offset); err != nil {
+		return err
+	}
+	r.nextMark = now.Truncate(markInterval).Add(markInterval)
+	r.segments[len(r.segments)-1].Size = r.offset
+	if r.retention(now) {
+		return writeIndex(r.opts.Dir, r.opts.Prefix, r.segments)
+	}
+	return nil
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	if err := r.closeSegment(now); err != nil {
+		return err
+	}
+	name := segmentName(r.opts.Prefix, now)
+	path := filepath.Join(r.opts.Dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	marks, err := os.OpenFile(path+marksExt, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		marks.Close()
+		return err
+	}
+	//a restart within the same second continues the existing segment
+	if n := len(r.segments); n > 0 && r.segments[n-1].File == name {
+		r.segments = r.segments[:n-1]
+	}
+	r.file = f
+	r.marks = marks
+	r.w = bufio.NewWriterSize(f, 1<<20)
+	r.offset = fi.Size()
+	r.rotateAt = now.Truncate(r.opts.Rotate).Add(r.opts.Rotate)
+	r.nextMark = time.Time{}
+	r.segments = append(r.segments, Segment{
+		File:  name,
+		Start: now.UTC().Truncate(time.Second),
+		Size:  r.offset,
+	})
+	logging.Log.Info().Str("identifier", r.opts.Prefix).Msgf("recording to %s", path)
+	r.retention(now)
+	return writeIndex(r.opts.Dir, r.opts.Prefix, r.segments)
+}
+
+// closeSegment closes the segment being written, if any
+func (r *Recorder) closeSegment(now time.Time) error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := r.marks.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	r.marks = nil
+	current := &r.segments[len(r.segments)-1]
+	current.End = now.UTC()
+	current.Size = r.offset
+	return err
+}
+
+// retention removes the finished segments past MaxAge or over MaxSize, it
+// returns whether any were removed
+func (r *Recorder) retention(now time.Time) bool {
+	finished := len(r.segments)
+	if r.file != nil {
+		finished--
+	}
+	total := int64(0)
+	for _, s := range r.segments {
+		total += s.Size
+	}
+	removed := 0
+	for removed < finished {
+		s := r.segments[removed]
+		expired := r.opts.MaxAge > 0 && now.Sub(s.End) > r.opts.MaxAge
+		oversize := r.opts.MaxSize > 0 && total > r.opts.MaxSize
+		if !expired && !oversize {
+			break
+		}
+		path := filepath.Join(r.opts.Dir, s.File)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logging.Log.Error().Str("identifier", r.opts.Prefix).Err(err).Msgf("couldn't remove recording %s", path)
+		}
+		if err := os.Remove(path + marksExt); err != nil && !os.IsNotExist(err) {
+			logging.Log.Error().Str("identifier", r.opts.Prefix).Err(err).Msgf("couldn't remove recording index %s", path+marksExt)
+		}
+		logging.Log.Info().Str("identifier", r.opts.Prefix).Msgf("removed recording %s", path)
+		total -= s.Size
+		removed++
+	}
+	r.segments = r.segments[removed:]
+	return removed > 0
+}
+
+// Close finishes the current segment and updates the index
+func (r *Recorder) Close() error {
+	now := time.Now()
+	err := r.closeSegment(now)
+	if ierr := writeIndex(r.opts.Dir, r.opts.Prefix, r.segments); err == nil {
+		err = ierr
+	}
+	return err
+}
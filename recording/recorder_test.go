@@ -0,0 +1,124 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package recording
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testPrefix = "test"
+	//a block of 7 packets is recorded every testInterval
+	testBlockSize = 7 * tsPacketSize
+	testInterval  = 100 * time.Millisecond
+	testBlocks    = 250
+)
+
+var testStart = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// blockTime returns when block i was recorded
+func blockTime(i int) time.Time {
+	return testStart.Add(time.Duration(i) * testInterval)
+}
+
+// record writes testBlocks blocks in 10s segments to a new directory, every
+// packet carries the index of its block
+func record(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "streamzeug-recording-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(Options{Dir: dir, Prefix: testPrefix, Rotate: 10 * time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	data := make([]byte, testBlockSize)
+	for i := 0; i < testBlocks; i++ {
+		for p := 0; p < len(data); p += tsPacketSize {
+			data[p] = 0x47
+			data[p+1] = byte(i >> 8)
+			data[p+2] = byte(i)
+		}
+		if err := r.Write(data, blockTime(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//the end of a finished segment is its modification time
+	segments, err := Segments(dir, testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range segments {
+		end := blockTime(testBlocks)
+		if i+1 < len(segments) {
+			end = segments[i+1].Start
+		}
+		if err := os.Chtimes(filepath.Join(dir, s.File), end, end); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRotation(t *testing.T) {
+	dir := record(t)
+	defer os.RemoveAll(dir)
+	segments, err := Segments(dir, testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test-20210601T120000Z.ts", "test-20210601T120010Z.ts", "test-20210601T120020Z.ts"}
+	if len(segments) != len(want) {
+		t.Fatalf("expected %d segments, got %+v", len(want), segments)
+	}
+	for i, s := range segments {
+		if s.File != want[i] {
+			t.Fatalf("expected segment %s, got %s", want[i], s.File)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, testPrefix+indexExt)); err != nil {
+		t.Fatalf("index not written: %v", err)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	dir := record(t)
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		at      time.Time
+		file    string
+		offset  int64
+		wantErr error
+	}{
+		{at: testStart, file: "test-20210601T120000Z.ts", offset: 0},
+		{at: testStart.Add(3500 * time.Millisecond), file: "test-20210601T120000Z.ts", offset: 30 * testBlockSize},
+		{at: testStart.Add(15 * time.Second), file: "test-20210601T120010Z.ts", offset: 50 * testBlockSize},
+		{at: testStart.Add(-time.Second), wantErr: ErrNotRecorded},
+		{at: testStart.Add(time.Hour), wantErr: ErrNotRecorded},
+	}
+	for _, tt := range tests {
+		s, offset, err := Locate(dir, testPrefix, tt.at)
+		if err != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.at, tt.wantErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if s.File != tt.file || offset != tt.offset {
+			t.Errorf("%s: expected %s at %d, got %s at %d", tt.at, tt.file, tt.offset, s.File, offset)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package stats
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/haivision/srtgo"
+	"github.com/odmedia/streamzeug/input/udp/udpstats"
+	"github.com/odmedia/streamzeug/mainloop/mlstats"
+	"github.com/odmedia/streamzeug/output/clientstats"
+	"github.com/odmedia/streamzeug/output/dektecasi/dtstats"
+)
+
+// kinds of stats, mapped to a measurement or metric name by the backends
+const (
+	kindSrt       = "srt"
+	kindRistRX    = "rist-receive"
+	kindRistTX    = "rist-sender"
+	kindUdpRX     = "udp-receive"
+	kindFailover  = "failover"
+	kindMerge     = "merge"
+	kindTR101290  = "tr101290"
+	kindPID       = "pid"
+	kindDektecAsi = "dektecasi"
+	kindClient    = "stream-client"
+)
+
+func structToMap(s interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+	elem := reflect.ValueOf(s).Elem()
+	relType := elem.Type()
+	for i := 0; i < relType.NumField(); i++ {
+		m[relType.Field(i).Name] = elem.Field(i).Interface()
+	}
+	return m
+}
+
+// statsFields returns the kind, tags and values of stats as reported to the
+// stats backends
+func (s *Stats) statsFields(host, output_identifier string, u *url.URL, stats interface{}) (string, map[string]string, map[string]interface{}) {
+	values := structToMap(stats)
+	var (
+		kind  string
+		cname string
+	)
+	tags := map[string]string{"identifier": s.identifier}
+	switch stats.(type) {
+	case *libristwrapper.ReceiverFlowStats:
+		kind = kindRistRX
+		cname = values["CName"].(string)
+		delete(values, "CName")
+	case *libristwrapper.SenderPeerStats:
+		kind = kindRistTX
+		cname = values["CName"].(string)
+		delete(values, "CName")
+	case *srtgo.SrtStats:
+		kind = kindSrt
+	case *udpstats.UdpInputStats:
+		kind = kindUdpRX
+	case *mlstats.FailoverStats:
+		kind = kindFailover
+	case *mlstats.MergeStats:
+		kind = kindMerge
+	case *mlstats.TR101290Stats:
+		kind = kindTR101290
+	case *mlstats.PIDStats:
+		kind = kindPID
+		tags["pid"] = strconv.FormatInt(int64(values["PID"].(int)), 10)
+		delete(values, "PID")
+	case *dtstats.DektecAsiStats:
+		kind = kindDektecAsi
+		tags["port"] = strconv.FormatInt(int64(values["AsiPortno"].(int)), 10)
+		delete(values, "AsiPortno")
+	case *clientstats.ClientStats:
+		kind = kindClient
+	default:
+		panic("wrong interface")
+	}
+	if host != "" {
+		tags["remotehost"] = host
+	}
+	if u != nil {
+		tags["localurl"] = u.Host
+	}
+	if output_identifier != "" {
+		tags["output_identifier"] = output_identifier
+	}
+	if cname != "" {
+		tags["cname"] = cname
+	}
+	return kind, tags, values
+}
@@ -9,19 +9,14 @@ package stats
 import (
 	"context"
 	"net/url"
-	"reflect"
-	"strconv"
 	"sync"
 	"time"
 
-	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/Showmax/go-fqdn"
-	"github.com/haivision/srtgo"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/odmedia/streamzeug/config"
 	"github.com/odmedia/streamzeug/logging"
-	"github.com/odmedia/streamzeug/output/dektecasi/dtstats"
 	"github.com/odmedia/streamzeug/version"
 	"github.com/sam-kamerer/go-runtime-metrics/v2/pkg/collector"
 )
@@ -34,6 +29,12 @@ var (
 	srtmeasurement         string
 	ristrxmeasurement      string
 	risttxmeasurement      string
+	udprxmeasurement       string
+	failovermeasurement    string
+	mergemeasurement       string
+	tr101290measurement    string
+	pidmeasurement         string
+	clientmeasurement      string
 	applicationmeasurement string
 )
 
@@ -44,6 +45,12 @@ func SetupInfluxDB(ctx context.Context, c *config.InfluxDBConfig, identifier str
 	srtmeasurement = "srt"
 	ristrxmeasurement = "rist-receive"
 	risttxmeasurement = "rist-sender"
+	udprxmeasurement = "udp-receive"
+	failovermeasurement = "failover"
+	mergemeasurement = "merge"
+	tr101290measurement = "tr101290"
+	pidmeasurement = "pid"
+	clientmeasurement = "stream-client"
 	applicationmeasurement = "streamzeug"
 	if c.SrtMeasurement != "" {
 		srtmeasurement = c.SrtMeasurement
@@ -54,6 +61,24 @@ func SetupInfluxDB(ctx context.Context, c *config.InfluxDBConfig, identifier str
 	if c.RistTXMeasurement != "" {
 		risttxmeasurement = c.RistTXMeasurement
 	}
+	if c.UdpRXMeasurement != "" {
+		udprxmeasurement = c.UdpRXMeasurement
+	}
+	if c.FailoverMeasurement != "" {
+		failovermeasurement = c.FailoverMeasurement
+	}
+	if c.MergeMeasurement != "" {
+		mergemeasurement = c.MergeMeasurement
+	}
+	if c.TR101290Measurement != "" {
+		tr101290measurement = c.TR101290Measurement
+	}
+	if c.PIDMeasurement != "" {
+		pidmeasurement = c.PIDMeasurement
+	}
+	if c.ClientMeasurement != "" {
+		clientmeasurement = c.ClientMeasurement
+	}
 	if c.ApplicationMeasurement != "" {
 		applicationmeasurement = c.ApplicationMeasurement
 	}
@@ -73,57 +98,41 @@ func InfluxDisable() {
 	configlock.Unlock()
 }
 
-func structToMap(s interface{}) map[string]interface{} {
-	m := make(map[string]interface{})
-	elem := reflect.ValueOf(s).Elem()
-	relType := elem.Type()
-	for i := 0; i < relType.NumField(); i++ {
-		m[relType.Field(i).Name] = elem.Field(i).Interface()
+// influxMeasurement returns the measurement name configured for kind,
+// configlock must be held
+func influxMeasurement(kind string) string {
+	switch kind {
+	case kindRistRX:
+		return ristrxmeasurement
+	case kindRistTX:
+		return risttxmeasurement
+	case kindSrt:
+		return srtmeasurement
+	case kindUdpRX:
+		return udprxmeasurement
+	case kindFailover:
+		return failovermeasurement
+	case kindMerge:
+		return mergemeasurement
+	case kindTR101290:
+		return tr101290measurement
+	case kindPID:
+		return pidmeasurement
+	case kindDektecAsi:
+		return "dektekasi"
+	case kindClient:
+		return clientmeasurement
 	}
-	return m
+	panic("wrong interface")
 }
 
 func (s *Stats) writeInfluxStats(host, output_identifier string, u *url.URL, stats interface{}) {
 	configlock.RLock()
 	defer configlock.RUnlock()
-	values := structToMap(stats)
-	var (
-		measurement string
-		cname       string
-	)
-	tags := map[string]string{"identifier": s.identifier, "hostname": hostname}
-	switch stats.(type) {
-	case *libristwrapper.ReceiverFlowStats:
-		measurement = ristrxmeasurement
-		cname = values["CName"].(string)
-		delete(values, "CName")
-	case *libristwrapper.SenderPeerStats:
-		measurement = risttxmeasurement
-		cname = values["CName"].(string)
-		delete(values, "CName")
-	case *srtgo.SrtStats:
-		measurement = srtmeasurement
-	case *dtstats.DektecAsiStats:
-		measurement = "dektekasi"
-		tags["port"] = strconv.FormatInt(int64(values["AsiPortno"].(int)), 10)
-		delete(values, "AsiPortno")
-	default:
-		panic("wrong interface")
-	}
-	if host != "" {
-		tags["remotehost"] = host
-	}
-	if u != nil {
-		tags["localurl"] = u.Host
-	}
-	if output_identifier != "" {
-		tags["output_identifier"] = output_identifier
-	}
-	if cname != "" {
-		tags["cname"] = cname
-	}
+	kind, tags, values := s.statsFields(host, output_identifier, u, stats)
+	tags["hostname"] = hostname
 	point := influxdb2.NewPoint(
-		measurement,
+		influxMeasurement(kind),
 		tags,
 		values,
 		time.Now(),
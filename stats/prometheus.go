@@ -0,0 +1,209 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package stats
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/odmedia/streamzeug/version"
+	"github.com/sam-kamerer/go-runtime-metrics/v2/pkg/collector"
+)
+
+const promPrefix = "streamzeug_"
+
+// Metric is a single gauge sample in the Prometheus exposition
+type Metric struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+type promSample struct {
+	Metric
+	updated time.Time
+}
+
+var (
+	promlock      sync.Mutex
+	promsamples   = make(map[string]*promSample)
+	promcollector *collector.Collector
+)
+
+// storePrometheus keeps the latest values of stats for the next scrape
+func (s *Stats) storePrometheus(host, output_identifier string, u *url.URL, stats interface{}) {
+	kind, tags, values := s.statsFields(host, output_identifier, u, stats)
+	now := time.Now()
+	promlock.Lock()
+	defer promlock.Unlock()
+	for field, v := range values {
+		value, ok := promValue(v)
+		if !ok {
+			continue
+		}
+		m := Metric{
+			Name:   promPrefix + promName(kind) + "_" + snakeCase(field),
+			Labels: tags,
+			Value:  value,
+		}
+		key := m.Name + promLabels(m.Labels)
+		promsamples[key] = &promSample{m, now}
+	}
+}
+
+// promValue converts numeric and boolean stats values, strings and other
+// types are not exposed
+func promValue(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// promName replaces all characters not valid in a metric name
+func promName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// snakeCase converts struct field names such as PktRecvLoss or CCErrors to
+// pkt_recv_loss and cc_errors
+func snakeCase(s string) string {
+	var b strings.Builder
+	r := []rune(promName(s))
+	for i, c := range r {
+		upper := c >= 'A' && c <= 'Z'
+		if upper && i > 0 && r[i-1] != '_' {
+			prevLower := r[i-1] >= 'a' && r[i-1] <= 'z' || r[i-1] >= '0' && r[i-1] <= '9'
+			nextLower := i+1 < len(r) && r[i+1] >= 'a' && r[i+1] <= 'z'
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		if upper {
+			c += 'a' - 'A'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// promLabels renders labels sorted by name
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(promName(n))
+		b.WriteString(`="`)
+		b.WriteString(escaper.Replace(labels[n]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// runtimeMetrics returns the application level stats also pushed to
+// InfluxDB, promlock must be held
+func runtimeMetrics() []Metric {
+	if promcollector == nil {
+		promcollector = collector.New(nil)
+	}
+	fields := promcollector.CollectStats()
+	tags := fields.Tags()
+	metrics := []Metric{{
+		Name: promPrefix + "info",
+		Labels: map[string]string{
+			"version":    version.CombinedVersion,
+			"go_os":      tags["go.os"],
+			"go_arch":    tags["go.arch"],
+			"go_version": tags["go.version"],
+		},
+		Value: 1,
+	}}
+	for field, v := range fields.Values() {
+		value, ok := promValue(v)
+		if !ok {
+			continue
+		}
+		metrics = append(metrics, Metric{
+			Name:  promPrefix + "runtime_" + promName(field),
+			Value: value,
+		})
+	}
+	return metrics
+}
+
+// WritePrometheus writes extra, the latest stats reported via HandleStats and
+// the runtime stats in the Prometheus text format. Stats not reported for 3
+// intervals, for example of stopped flows, are no longer written.
+func WritePrometheus(w io.Writer, extra []Metric) error {
+	promlock.Lock()
+	expire := time.Now().Add(-3 * time.Duration(StatsIntervalSeconds) * time.Second)
+	metrics := append(append([]Metric(nil), extra...), runtimeMetrics()...)
+	for key, s := range promsamples {
+		if s.updated.Before(expire) {
+			delete(promsamples, key)
+			continue
+		}
+		metrics = append(metrics, s.Metric)
+	}
+	promlock.Unlock()
+
+	series := make(map[string][]string)
+	for _, m := range metrics {
+		series[m.Name] = append(series[m.Name], promLabels(m.Labels)+" "+strconv.FormatFloat(m.Value, 'g', -1, 64))
+	}
+	names := make([]string, 0, len(series))
+	for n := range series {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for _, n := range names {
+		sort.Strings(series[n])
+		bw.WriteString("# TYPE " + n + " gauge\n")
+		for _, s := range series[n] {
+			bw.WriteString(n + s + "\n")
+		}
+	}
+	return bw.Flush()
+}
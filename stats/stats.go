@@ -15,7 +15,10 @@ import (
 	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/haivision/srtgo"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	"github.com/odmedia/streamzeug/input/udp/udpstats"
 	"github.com/odmedia/streamzeug/logging"
+	"github.com/odmedia/streamzeug/mainloop/mlstats"
+	"github.com/odmedia/streamzeug/output/clientstats"
 	"github.com/odmedia/streamzeug/output/dektecasi/dtstats"
 )
 
@@ -80,6 +83,36 @@ type wrappedRistSenderStats struct {
 	*libristwrapper.SenderPeerStats
 }
 
+type wrappedUdpInputStats struct {
+	*statsPrepend
+	*udpstats.UdpInputStats
+}
+
+type wrappedFailoverStats struct {
+	*statsPrepend
+	*mlstats.FailoverStats
+}
+
+type wrappedMergeStats struct {
+	*statsPrepend
+	*mlstats.MergeStats
+}
+
+type wrappedTR101290Stats struct {
+	*statsPrepend
+	*mlstats.TR101290Stats
+}
+
+type wrappedPIDStats struct {
+	*statsPrepend
+	*mlstats.PIDStats
+}
+
+type wrappedClientStats struct {
+	*statsPrepend
+	*clientstats.ClientStats
+}
+
 type wrappedDektecAsiStats struct {
 	*statsPrepend
 	*dtstats.DektecAsiStats
@@ -100,9 +133,27 @@ func (s *Stats) HandleStats(Host, identifier string, u *url.URL, stats interface
 		case *libristwrapper.SenderPeerStats:
 			prepend.Type = "RistSenderStats"
 			wrappedStats = &wrappedRistSenderStats{prepend, v}
+		case *udpstats.UdpInputStats:
+			prepend.Type = "UdpInputStats"
+			wrappedStats = &wrappedUdpInputStats{prepend, v}
+		case *mlstats.FailoverStats:
+			prepend.Type = "FailoverStats"
+			wrappedStats = &wrappedFailoverStats{prepend, v}
+		case *mlstats.MergeStats:
+			prepend.Type = "MergeStats"
+			wrappedStats = &wrappedMergeStats{prepend, v}
+		case *mlstats.TR101290Stats:
+			prepend.Type = "TR101290Stats"
+			wrappedStats = &wrappedTR101290Stats{prepend, v}
+		case *mlstats.PIDStats:
+			prepend.Type = "PIDStats"
+			wrappedStats = &wrappedPIDStats{prepend, v}
 		case *dtstats.DektecAsiStats:
 			prepend.Type = "DektecAsiStats"
 			wrappedStats = &wrappedDektecAsiStats{prepend, v}
+		case *clientstats.ClientStats:
+			prepend.Type = "ClientStats"
+			wrappedStats = &wrappedClientStats{prepend, v}
 		default:
 			panic("unhandled stats")
 		}
@@ -121,6 +172,7 @@ func (s *Stats) HandleStats(Host, identifier string, u *url.URL, stats interface
 		}
 	}
 
+	s.storePrometheus(Host, identifier, u, stats)
 	if influxDBWriteApi != nil {
 		s.writeInfluxStats(Host, identifier, u, stats)
 	}
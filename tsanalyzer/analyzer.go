@@ -0,0 +1,490 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+// Package tsanalyzer checks MPEG transport streams against the priority 1
+// and 2 indicators of ETSI TR 101 290.
+package tsanalyzer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type ErrorType int
+
+const (
+	//priority 1
+	TSSyncLoss ErrorType = iota
+	SyncByteError
+	PATError
+	ContinuityCountError
+	PMTError
+	PIDError
+	//priority 2
+	TransportError
+	CRCError
+	PCRRepetitionError
+	PCRDiscontinuityIndicatorError
+	PTSError
+	CATError
+	numErrors
+)
+
+var errorNames = [numErrors]string{
+	"TS_sync_loss",
+	"Sync_byte_error",
+	"PAT_error",
+	"Continuity_count_error",
+	"PMT_error",
+	"PID_error",
+	"Transport_error",
+	"CRC_error",
+	"PCR_repetition_error",
+	"PCR_discontinuity_indicator_error",
+	"PTS_error",
+	"CAT_error",
+}
+
+const (
+	//consecutive sync bytes needed to acquire sync
+	syncPackets = 5
+	//consecutive corrupted sync bytes after which sync is lost
+	syncLossPackets      = 2
+	patInterval          = 500 * time.Millisecond
+	pmtInterval          = 500 * time.Millisecond
+	pidInterval          = 5 * time.Second
+	pcrInterval          = 100 * time.Millisecond
+	pcrDiscontinuity     = pcrHz / 10
+	ptsInterval          = 700 * time.Millisecond
+	timeoutCheckInterval = 100 * time.Millisecond
+)
+
+func (e ErrorType) String() string {
+	return errorNames[e]
+}
+
+// Priority returns the TR 101 290 priority of e
+func (e ErrorType) Priority() int {
+	if e <= PIDError {
+		return 1
+	}
+	return 2
+}
+
+// ParseErrorType returns the ErrorType named as in TR 101 290, e.g.
+// TS_sync_loss
+func ParseErrorType(name string) (ErrorType, bool) {
+	for i, n := range errorNames {
+		if n == name {
+			return ErrorType(i), true
+		}
+	}
+	return 0, false
+}
+
+type ErrorStatus struct {
+	Count          int        `json:"count"`
+	LastOccurrence *time.Time `json:"lastoccurrence,omitempty"`
+}
+
+type Status struct {
+	Synced    bool                   `json:"synced"`
+	Priority1 map[string]ErrorStatus `json:"priority1"`
+	Priority2 map[string]ErrorStatus `json:"priority2"`
+}
+
+type pidstate struct {
+	cc          uint8
+	hasCC       bool
+	duplicates  int
+	lastSeen    time.Time
+	lastPCR     uint64
+	hasPCR      bool
+	lastPCRTime time.Time
+	lastPTSTime time.Time
+	//packets counted, in total and in the current bitrate window
+	packets       int
+	windowPackets int
+	bitrate       int
+}
+
+// Analyzer is fed the transport stream of a flow, it is safe for concurrent
+// use.
+type Analyzer struct {
+	lock       sync.Mutex
+	logger     zerolog.Logger
+	buf        []byte
+	synced     bool
+	badSync    int
+	now        time.Time
+	lastCheck  time.Time
+	counts     [numErrors]int
+	last       [numErrors]time.Time
+	pids       [PIDNull + 1]*pidstate
+	sections   map[uint16]*sectionBuffer
+	patVersion int
+	lastPAT    time.Time
+	programs   map[uint16]*program
+	lastPMT    map[uint16]time.Time
+	catSeen    bool
+	sdtVersion int
+	sdt        map[uint16]sdtService
+	//set when a table was updated, the service list is compared after
+	//processing the data fed
+	psiChanged   bool
+	lastServices []Service
+	windowStart  time.Time
+	bitrate      int
+}
+
+// New returns an Analyzer logging service table changes to logger
+func New(logger zerolog.Logger) *Analyzer {
+	return &Analyzer{
+		logger:     logger,
+		sections:   make(map[uint16]*sectionBuffer),
+		patVersion: -1,
+		programs:   make(map[uint16]*program),
+		lastPMT:    make(map[uint16]time.Time),
+		sdtVersion: -1,
+		sdt:        make(map[uint16]sdtService),
+	}
+}
+
+func (a *Analyzer) error(e ErrorType) {
+	a.counts[e]++
+	a.last[e] = a.now
+}
+
+func (a *Analyzer) pidState(pid uint16) *pidstate {
+	ps := a.pids[pid]
+	if ps == nil {
+		ps = &pidstate{}
+		a.pids[pid] = ps
+	}
+	return ps
+}
+
+// findSync returns the offset of the first syncPackets consecutive packets
+func findSync(buf []byte) (int, bool) {
+	for offset := 0; offset+syncPackets*PacketSize <= len(buf); offset++ {
+		found := true
+		for i := 0; i < syncPackets; i++ {
+			if buf[offset+i*PacketSize] != SyncByte {
+				found = false
+				break
+			}
+		}
+		if found {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// resync drops all state depending on packet continuity
+func (a *Analyzer) resync() {
+	for _, ps := range a.pids {
+		if ps != nil {
+			ps.hasCC = false
+			ps.hasPCR = false
+		}
+	}
+	for _, s := range a.sections {
+		s.active = false
+	}
+}
+
+// Feed analyzes data, which doesn't have to be aligned on packet boundaries,
+// received at now.
+func (a *Analyzer) Feed(data []byte, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.now = now
+	a.buf = append(a.buf, data...)
+	buf := a.buf
+	for {
+		if !a.synced {
+			offset, ok := findSync(buf)
+			if !ok {
+				if keep := syncPackets*PacketSize - 1; len(buf) > keep {
+					buf = buf[len(buf)-keep:]
+				}
+				break
+			}
+			buf = buf[offset:]
+			a.synced = true
+			a.badSync = 0
+			a.resync()
+			if a.lastPAT.IsZero() {
+				a.lastPAT = now
+			}
+		}
+		if len(buf) < PacketSize {
+			break
+		}
+		p := packet(buf[:PacketSize])
+		buf = buf[PacketSize:]
+		if p[0] != SyncByte {
+			a.error(SyncByteError)
+			a.badSync++
+			if a.badSync >= syncLossPackets {
+				a.error(TSSyncLoss)
+				a.synced = false
+			}
+			continue
+		}
+		a.badSync = 0
+		a.packet(p)
+	}
+	a.buf = a.buf[:copy(a.buf, buf)]
+	if a.psiChanged {
+		a.checkServices()
+	}
+	a.updateBitrates(now)
+	if a.synced && now.Sub(a.lastCheck) >= timeoutCheckInterval {
+		a.checkTimeouts()
+		a.lastCheck = now
+	}
+}
+
+func (a *Analyzer) packet(p packet) {
+	if p.transportError() {
+		//the header can't be trusted
+		a.error(TransportError)
+		return
+	}
+	pid := p.pid()
+	ps := a.pidState(pid)
+	ps.packets++
+	ps.windowPackets++
+	ps.lastSeen = a.now
+	if pid == PIDNull {
+		return
+	}
+	af := p.adaptation()
+	a.checkCC(p, ps, af)
+	if pcr, ok := pcr(af); ok {
+		a.checkPCR(ps, pcr, discontinuityIndicator(af))
+	}
+	_, isPMT := a.lastPMT[pid]
+	if p.scrambled() {
+		switch {
+		case pid == PIDPAT:
+			a.error(PATError)
+		case isPMT:
+			a.error(PMTError)
+		case !a.catSeen:
+			a.error(CATError)
+		}
+		return
+	}
+	payload := p.payload()
+	switch {
+	case pid == PIDPAT, pid == PIDCAT, pid == PIDNIT, pid == PIDSDT, isPMT:
+		s := a.sections[pid]
+		if s == nil {
+			s = &sectionBuffer{}
+			a.sections[pid] = s
+		}
+		s.push(p, payload, func(data []byte) {
+			a.section(pid, data)
+		})
+	case p.unitStart() && pts(payload):
+		if !ps.lastPTSTime.IsZero() && a.now.Sub(ps.lastPTSTime) > ptsInterval {
+			a.error(PTSError)
+		}
+		ps.lastPTSTime = a.now
+	}
+}
+
+func (a *Analyzer) checkCC(p packet, ps *pidstate, af []byte) {
+	//the continuity counter only increments on packets with payload
+	if !p.hasPayload() {
+		return
+	}
+	cc := p.cc()
+	if !ps.hasCC || discontinuityIndicator(af) {
+		ps.cc = cc
+		ps.hasCC = true
+		ps.duplicates = 0
+		return
+	}
+	switch cc {
+	case (ps.cc + 1) & 0x0f:
+		ps.duplicates = 0
+	case ps.cc:
+		//a packet may be sent twice, not more
+		ps.duplicates++
+		if ps.duplicates > 1 {
+			a.error(ContinuityCountError)
+		}
+	default:
+		a.error(ContinuityCountError)
+		ps.duplicates = 0
+	}
+	ps.cc = cc
+}
+
+func (a *Analyzer) checkPCR(ps *pidstate, pcr uint64, discontinuity bool) {
+	if ps.hasPCR {
+		if a.now.Sub(ps.lastPCRTime) > pcrInterval {
+			a.error(PCRRepetitionError)
+		}
+		//negative differences wrap to large values
+		if diff := (pcr + pcrWrap - ps.lastPCR) % pcrWrap; diff > pcrDiscontinuity && !discontinuity {
+			a.error(PCRDiscontinuityIndicatorError)
+		}
+	}
+	ps.lastPCR = pcr
+	ps.hasPCR = true
+	ps.lastPCRTime = a.now
+}
+
+func (a *Analyzer) section(pid uint16, data []byte) {
+	if data[1]&0x80 != 0 && crc32(data) != 0 {
+		a.error(CRCError)
+		return
+	}
+	switch pid {
+	case PIDPAT:
+		if data[0] != tableIDPAT {
+			a.error(PATError)
+			return
+		}
+		a.lastPAT = a.now
+		if s, ok := parseSection(data); ok && s.currentNext {
+			a.updatePAT(s)
+		}
+		return
+	case PIDCAT:
+		if data[0] != tableIDCAT {
+			a.error(CATError)
+			return
+		}
+		a.catSeen = true
+		return
+	case PIDSDT:
+		if data[0] != tableIDSDT {
+			return
+		}
+		if s, ok := parseSection(data); ok && s.currentNext {
+			a.updateSDT(s)
+		}
+		return
+	}
+	if _, isPMT := a.lastPMT[pid]; !isPMT {
+		return
+	}
+	if data[0] != tableIDPMT {
+		a.error(PMTError)
+		return
+	}
+	a.lastPMT[pid] = a.now
+	s, ok := parseSection(data)
+	if !ok || !s.currentNext {
+		return
+	}
+	prog := a.programs[s.tableIDExt]
+	if prog == nil || prog.pmtPID != pid || prog.version == int(s.version) {
+		return
+	}
+	if !parsePMT(s, prog) {
+		return
+	}
+	a.psiChanged = true
+	for _, es := range prog.streams {
+		//start the PID_error timer for streams not seen yet
+		if ps := a.pidState(es.pid); ps.lastSeen.IsZero() {
+			ps.lastSeen = a.now
+		}
+	}
+}
+
+func (a *Analyzer) updatePAT(s section) {
+	if int(s.version) != a.patVersion {
+		a.patVersion = int(s.version)
+		a.programs = make(map[uint16]*program)
+		a.psiChanged = true
+	}
+	for number, pid := range parsePAT(s) {
+		if prog, ok := a.programs[number]; ok && prog.pmtPID == pid {
+			continue
+		}
+		a.psiChanged = true
+		a.programs[number] = &program{
+			number:  number,
+			pmtPID:  pid,
+			version: -1,
+		}
+	}
+	lastPMT := make(map[uint16]time.Time)
+	for _, prog := range a.programs {
+		t, ok := a.lastPMT[prog.pmtPID]
+		if !ok {
+			t = a.now
+		}
+		lastPMT[prog.pmtPID] = t
+	}
+	a.lastPMT = lastPMT
+}
+
+func (a *Analyzer) checkTimeouts() {
+	if a.now.Sub(a.lastPAT) > patInterval {
+		a.error(PATError)
+		a.lastPAT = a.now
+	}
+	for pid, t := range a.lastPMT {
+		if a.now.Sub(t) > pmtInterval {
+			a.error(PMTError)
+			a.lastPMT[pid] = a.now
+		}
+	}
+	for _, prog := range a.programs {
+		for _, es := range prog.streams {
+			ps := a.pids[es.pid]
+			if ps != nil && a.now.Sub(ps.lastSeen) > pidInterval {
+				a.error(PIDError)
+				ps.lastSeen = a.now
+			}
+		}
+	}
+}
+
+func (a *Analyzer) Status() *Status {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	status := &Status{
+		Synced:    a.synced,
+		Priority1: make(map[string]ErrorStatus),
+		Priority2: make(map[string]ErrorStatus),
+	}
+	for i := ErrorType(0); i < numErrors; i++ {
+		e := ErrorStatus{
+			Count: a.counts[i],
+		}
+		if a.counts[i] > 0 {
+			last := a.last[i]
+			e.LastOccurrence = &last
+		}
+		if i.Priority() == 1 {
+			status.Priority1[i.String()] = e
+		} else {
+			status.Priority2[i.String()] = e
+		}
+	}
+	return status
+}
+
+// Counts returns the total number of errors, indexed by ErrorType
+func (a *Analyzer) Counts() []int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	counts := make([]int, numErrors)
+	copy(counts, a.counts[:])
+	return counts
+}
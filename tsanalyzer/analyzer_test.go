@@ -0,0 +1,213 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsanalyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	testPMTPID = 0x1000
+	testESPID  = 0x0100
+)
+
+func tsPacket(pid uint16, cc uint8, unitStart bool, payload []byte) []byte {
+	p := make([]byte, PacketSize)
+	p[0] = SyncByte
+	p[1] = byte(pid>>8) & 0x1f
+	if unitStart {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10 | cc&0x0f
+	n := copy(p[4:], payload)
+	for i := 4 + n; i < PacketSize; i++ {
+		p[i] = 0xff
+	}
+	return p
+}
+
+func scrambled(p []byte) []byte {
+	p[3] |= 0x80
+	return p
+}
+
+func nullPackets(n int) [][]byte {
+	var packets [][]byte
+	for i := 0; i < n; i++ {
+		packets = append(packets, tsPacket(PIDNull, 0, false, nil))
+	}
+	return packets
+}
+
+// psiSection returns a long form section with a valid CRC
+func psiSection(tableID byte, ext uint16, body []byte) []byte {
+	l := 5 + len(body) + 4
+	s := []byte{tableID, 0xb0 | byte(l>>8), byte(l), byte(ext >> 8), byte(ext), 0xc1, 0, 0}
+	s = append(s, body...)
+	crc := crc32(s)
+	return append(s, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func patSection(tableID byte) []byte {
+	return psiSection(tableID, 1, []byte{0, 1, 0xe0 | byte(testPMTPID>>8), byte(testPMTPID & 0xff)})
+}
+
+func pmtSection(tableID byte) []byte {
+	return psiSection(tableID, 1, []byte{
+		0xe0 | byte(testESPID>>8), byte(testESPID & 0xff), 0xf0, 0,
+		0x1b, 0xe0 | byte(testESPID>>8), byte(testESPID & 0xff), 0xf0, 0,
+	})
+}
+
+// sectionPacket carries section in a single packet
+func sectionPacket(pid uint16, cc uint8, section []byte) []byte {
+	return tsPacket(pid, cc, true, append([]byte{0}, section...))
+}
+
+type feed struct {
+	at      time.Duration
+	packets [][]byte
+}
+
+func TestAnalyzerErrors(t *testing.T) {
+	corruptPAT := patSection(tableIDPAT)
+	corruptPAT[len(corruptPAT)-1] ^= 0xff
+
+	tests := []struct {
+		name  string
+		feeds []feed
+		want  map[ErrorType]int
+	}{
+		{
+			name: "continuous cc",
+			feeds: []feed{{0, [][]byte{
+				tsPacket(testESPID, 14, false, nil),
+				tsPacket(testESPID, 15, false, nil),
+				tsPacket(testESPID, 0, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+			}}},
+		},
+		{
+			name: "cc gap",
+			feeds: []feed{{0, [][]byte{
+				tsPacket(testESPID, 0, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+				tsPacket(testESPID, 3, false, nil),
+				tsPacket(testESPID, 4, false, nil),
+			}}},
+			want: map[ErrorType]int{ContinuityCountError: 1},
+		},
+		{
+			name: "cc duplicate",
+			feeds: []feed{{0, [][]byte{
+				tsPacket(testESPID, 0, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+				tsPacket(testESPID, 2, false, nil),
+			}}},
+		},
+		{
+			name: "cc repeated more than once",
+			feeds: []feed{{0, [][]byte{
+				tsPacket(testESPID, 0, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+				tsPacket(testESPID, 1, false, nil),
+			}}},
+			want: map[ErrorType]int{ContinuityCountError: 1},
+		},
+		{
+			name: "valid pat and pmt",
+			feeds: []feed{
+				{0, [][]byte{
+					sectionPacket(PIDPAT, 0, patSection(tableIDPAT)),
+					sectionPacket(testPMTPID, 0, pmtSection(tableIDPMT)),
+				}},
+				{400 * time.Millisecond, [][]byte{
+					sectionPacket(PIDPAT, 1, patSection(tableIDPAT)),
+					sectionPacket(testPMTPID, 1, pmtSection(tableIDPMT)),
+				}},
+			},
+		},
+		{
+			name: "pat missing",
+			feeds: []feed{
+				{0, nullPackets(1)},
+				{600 * time.Millisecond, nullPackets(1)},
+			},
+			want: map[ErrorType]int{PATError: 1},
+		},
+		{
+			name:  "pat wrong table id",
+			feeds: []feed{{0, [][]byte{sectionPacket(PIDPAT, 0, patSection(tableIDPMT))}}},
+			want:  map[ErrorType]int{PATError: 1},
+		},
+		{
+			name:  "pat scrambled",
+			feeds: []feed{{0, [][]byte{scrambled(sectionPacket(PIDPAT, 0, patSection(tableIDPAT)))}}},
+			want:  map[ErrorType]int{PATError: 1},
+		},
+		{
+			name:  "pat crc",
+			feeds: []feed{{0, [][]byte{sectionPacket(PIDPAT, 0, corruptPAT)}}},
+			want:  map[ErrorType]int{CRCError: 1},
+		},
+		{
+			name: "pmt missing",
+			feeds: []feed{
+				{0, [][]byte{sectionPacket(PIDPAT, 0, patSection(tableIDPAT))}},
+				{400 * time.Millisecond, [][]byte{sectionPacket(PIDPAT, 1, patSection(tableIDPAT))}},
+				{600 * time.Millisecond, [][]byte{sectionPacket(PIDPAT, 2, patSection(tableIDPAT))}},
+			},
+			want: map[ErrorType]int{PMTError: 1},
+		},
+		{
+			name: "pmt wrong table id",
+			feeds: []feed{{0, [][]byte{
+				sectionPacket(PIDPAT, 0, patSection(tableIDPAT)),
+				sectionPacket(testPMTPID, 0, pmtSection(tableIDPAT)),
+			}}},
+			want: map[ErrorType]int{PMTError: 1},
+		},
+		{
+			name: "pmt scrambled",
+			feeds: []feed{{0, [][]byte{
+				sectionPacket(PIDPAT, 0, patSection(tableIDPAT)),
+				scrambled(sectionPacket(testPMTPID, 0, pmtSection(tableIDPMT))),
+			}}},
+			want: map[ErrorType]int{PMTError: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := New(zerolog.Nop())
+			start := time.Now()
+			for i, f := range tt.feeds {
+				packets := f.packets
+				if i == 0 {
+					//acquire sync before the packets under test
+					packets = append(nullPackets(syncPackets), packets...)
+				}
+				var data []byte
+				for _, p := range packets {
+					data = append(data, p...)
+				}
+				a.Feed(data, start.Add(f.at))
+			}
+			counts := a.Counts()
+			for e := ErrorType(0); e < numErrors; e++ {
+				if counts[e] != tt.want[e] {
+					t.Errorf("%s: expected %d, got %d", e, tt.want[e], counts[e])
+				}
+			}
+		})
+	}
+}
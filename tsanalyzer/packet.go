@@ -0,0 +1,102 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsanalyzer
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+
+	PIDPAT  = 0x0000
+	PIDCAT  = 0x0001
+	PIDNIT  = 0x0010
+	PIDSDT  = 0x0011
+	PIDNull = 0x1fff
+
+	//27MHz PCR clock wraps at 2^33 * 300
+	pcrWrap = (1 << 33) * 300
+	pcrHz   = 27000000
+)
+
+type packet []byte
+
+func (p packet) pid() uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+func (p packet) transportError() bool {
+	return p[1]&0x80 != 0
+}
+
+func (p packet) unitStart() bool {
+	return p[1]&0x40 != 0
+}
+
+func (p packet) scrambled() bool {
+	return p[3]&0xc0 != 0
+}
+
+func (p packet) hasAdaptation() bool {
+	return p[3]&0x20 != 0
+}
+
+func (p packet) hasPayload() bool {
+	return p[3]&0x10 != 0
+}
+
+func (p packet) cc() uint8 {
+	return p[3] & 0x0f
+}
+
+// adaptation returns the adaptation field following its length byte
+func (p packet) adaptation() []byte {
+	if !p.hasAdaptation() {
+		return nil
+	}
+	l := int(p[4])
+	if l == 0 || 5+l > PacketSize {
+		return nil
+	}
+	return p[5 : 5+l]
+}
+
+func (p packet) payload() []byte {
+	offset := 4
+	if p.hasAdaptation() {
+		offset += 1 + int(p[4])
+	}
+	if !p.hasPayload() || offset >= PacketSize {
+		return nil
+	}
+	return p[offset:]
+}
+
+func discontinuityIndicator(af []byte) bool {
+	return len(af) > 0 && af[0]&0x80 != 0
+}
+
+// pcr returns the PCR in 27MHz units when af carries one
+func pcr(af []byte) (uint64, bool) {
+	if len(af) < 7 || af[0]&0x10 == 0 {
+		return 0, false
+	}
+	base := uint64(af[1])<<25 | uint64(af[2])<<17 | uint64(af[3])<<9 | uint64(af[4])<<1 | uint64(af[5])>>7
+	ext := uint64(af[5]&0x01)<<8 | uint64(af[6])
+	return base*300 + ext, true
+}
+
+// pts returns wether the PES header starting in payload carries a PTS
+func pts(payload []byte) bool {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return false
+	}
+	switch payload[3] {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		//stream ids without the optional PES header
+		return false
+	}
+	return payload[7]&0x80 != 0
+}
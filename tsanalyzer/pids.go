@@ -0,0 +1,83 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsanalyzer
+
+import "time"
+
+const bitrateWindow = time.Second
+
+type PIDStats struct {
+	PID     uint16 `json:"pid"`
+	Packets int    `json:"packets"`
+	Bitrate int    `json:"bitrate"`
+	// percentage of the transport stream bitrate
+	Share float64 `json:"share"`
+}
+
+type PIDStatus struct {
+	// transport stream bitrate including null packets
+	Bitrate int `json:"bitrate"`
+	// percentage of null packets
+	NullShare float64    `json:"nullshare"`
+	PIDs      []PIDStats `json:"pids"`
+}
+
+// updateBitrates calculates the bitrates over the window ending at now
+func (a *Analyzer) updateBitrates(now time.Time) {
+	if a.windowStart.IsZero() {
+		a.windowStart = now
+		return
+	}
+	elapsed := now.Sub(a.windowStart)
+	if elapsed < bitrateWindow {
+		return
+	}
+	total := 0
+	for _, ps := range a.pids {
+		if ps == nil {
+			continue
+		}
+		ps.bitrate = int(int64(ps.windowPackets) * PacketSize * 8 * int64(time.Second) / int64(elapsed))
+		total += ps.windowPackets
+		ps.windowPackets = 0
+	}
+	a.bitrate = int(int64(total) * PacketSize * 8 * int64(time.Second) / int64(elapsed))
+	a.windowStart = now
+}
+
+func share(bitrate, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(bitrate) * 100 / float64(total)
+}
+
+// PIDStatus returns the packet count and bitrate of every PID seen
+func (a *Analyzer) PIDStatus() *PIDStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.updateBitrates(time.Now())
+	status := &PIDStatus{
+		Bitrate: a.bitrate,
+		PIDs:    make([]PIDStats, 0),
+	}
+	for pid, ps := range a.pids {
+		if ps == nil || ps.packets == 0 {
+			continue
+		}
+		status.PIDs = append(status.PIDs, PIDStats{
+			PID:     uint16(pid),
+			Packets: ps.packets,
+			Bitrate: ps.bitrate,
+			Share:   share(ps.bitrate, a.bitrate),
+		})
+	}
+	if null := a.pids[PIDNull]; null != nil {
+		status.NullShare = share(null.bitrate, a.bitrate)
+	}
+	return status
+}
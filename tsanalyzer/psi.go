@@ -0,0 +1,332 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsanalyzer
+
+import "strings"
+
+const (
+	tableIDPAT = 0x00
+	tableIDCAT = 0x01
+	tableIDPMT = 0x02
+	//SDT of the actual transport stream
+	tableIDSDT = 0x42
+
+	descriptorLanguage     = 0x0a
+	descriptorService      = 0x48
+	descriptorTeletext     = 0x56
+	descriptorSubtitling   = 0x59
+	descriptorAC3          = 0x6a
+	descriptorEnhancedAC3  = 0x7a
+	descriptorExtension    = 0x7f
+	extensionDescriptorAC4 = 0x15
+)
+
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return
+}()
+
+// crc32 calculates the MPEG-2 CRC, which is 0 over a section including its
+// CRC when the section is intact
+func crc32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// sectionBuffer reassembles PSI sections carried in the packets of one PID
+type sectionBuffer struct {
+	buf    []byte
+	active bool
+}
+
+func sectionLength(data []byte) int {
+	return 3 + (int(data[1]&0x0f)<<8 | int(data[2]))
+}
+
+// push adds the payload of p, calling handle for every completed section
+func (s *sectionBuffer) push(p packet, payload []byte, handle func([]byte)) {
+	if len(payload) == 0 {
+		return
+	}
+	if !p.unitStart() {
+		if !s.active {
+			return
+		}
+		s.buf = append(s.buf, payload...)
+		if len(s.buf) >= 3 && len(s.buf) >= sectionLength(s.buf) {
+			handle(s.buf[:sectionLength(s.buf)])
+			s.active = false
+		}
+		return
+	}
+	pointer := int(payload[0])
+	payload = payload[1:]
+	if pointer > len(payload) {
+		s.active = false
+		return
+	}
+	if s.active {
+		s.buf = append(s.buf, payload[:pointer]...)
+		if len(s.buf) >= 3 && len(s.buf) >= sectionLength(s.buf) {
+			handle(s.buf[:sectionLength(s.buf)])
+		}
+	}
+	s.active = false
+	payload = payload[pointer:]
+	for len(payload) >= 3 && payload[0] != 0xff {
+		l := sectionLength(payload)
+		if len(payload) < l {
+			s.buf = append(s.buf[:0], payload...)
+			s.active = true
+			return
+		}
+		handle(payload[:l])
+		payload = payload[l:]
+	}
+}
+
+// section holds the common long form section header fields
+type section struct {
+	tableID       uint8
+	tableIDExt    uint16
+	version       uint8
+	currentNext   bool
+	sectionNumber uint8
+	data          []byte
+}
+
+// parseSection returns the parsed header and the data between header and
+// CRC of a long form section
+func parseSection(data []byte) (section, bool) {
+	if len(data) < 12 || data[1]&0x80 == 0 {
+		return section{}, false
+	}
+	return section{
+		tableID:       data[0],
+		tableIDExt:    uint16(data[3])<<8 | uint16(data[4]),
+		version:       (data[5] >> 1) & 0x1f,
+		currentNext:   data[5]&0x01 != 0,
+		sectionNumber: data[6],
+		data:          data[8 : len(data)-4],
+	}, true
+}
+
+type elementaryStream struct {
+	pid        uint16
+	streamType uint8
+	//name of the stream type, derived from the descriptors for private data
+	typeName string
+	language string
+}
+
+type program struct {
+	number  uint16
+	pmtPID  uint16
+	pcrPID  uint16
+	version int
+	streams []elementaryStream
+}
+
+// parsePAT returns the programme number to PMT PID mapping of a PAT section
+func parsePAT(s section) map[uint16]uint16 {
+	programs := make(map[uint16]uint16)
+	for d := s.data; len(d) >= 4; d = d[4:] {
+		number := uint16(d[0])<<8 | uint16(d[1])
+		pid := uint16(d[2]&0x1f)<<8 | uint16(d[3])
+		if number == 0 {
+			//network PID
+			continue
+		}
+		programs[number] = pid
+	}
+	return programs
+}
+
+// parsePMT fills p from a PMT section
+func parsePMT(s section, p *program) bool {
+	d := s.data
+	if len(d) < 4 {
+		return false
+	}
+	p.pcrPID = uint16(d[0]&0x1f)<<8 | uint16(d[1])
+	infoLength := int(d[2]&0x0f)<<8 | int(d[3])
+	if 4+infoLength > len(d) {
+		return false
+	}
+	p.version = int(s.version)
+	p.streams = p.streams[:0]
+	for d = d[4+infoLength:]; len(d) >= 5; {
+		es := elementaryStream{
+			streamType: d[0],
+			pid:        uint16(d[1]&0x1f)<<8 | uint16(d[2]),
+		}
+		esInfoLength := int(d[3]&0x0f)<<8 | int(d[4])
+		if 5+esInfoLength > len(d) {
+			return false
+		}
+		es.typeName = streamTypeName(es.streamType)
+		forEachDescriptor(d[5:5+esInfoLength], func(tag byte, data []byte) {
+			switch tag {
+			case descriptorLanguage:
+				if len(data) >= 3 {
+					es.language = dvbString(data[:3])
+				}
+			case descriptorAC3:
+				es.typeName = "AC-3 audio"
+			case descriptorEnhancedAC3:
+				es.typeName = "E-AC-3 audio"
+			case descriptorExtension:
+				if len(data) > 0 && data[0] == extensionDescriptorAC4 {
+					es.typeName = "AC-4 audio"
+				}
+			case descriptorTeletext:
+				es.typeName = "Teletext"
+			case descriptorSubtitling:
+				es.typeName = "DVB subtitles"
+				if len(data) >= 3 {
+					es.language = dvbString(data[:3])
+				}
+			}
+		})
+		p.streams = append(p.streams, es)
+		d = d[5+esInfoLength:]
+	}
+	return true
+}
+
+func forEachDescriptor(d []byte, handle func(tag byte, data []byte)) {
+	for len(d) >= 2 {
+		l := int(d[1])
+		if 2+l > len(d) {
+			return
+		}
+		handle(d[0], d[2:2+l])
+		d = d[2+l:]
+	}
+}
+
+type sdtService struct {
+	name     string
+	provider string
+	typ      uint8
+}
+
+// parseSDT returns the services described in an SDT section by service id
+func parseSDT(s section) map[uint16]sdtService {
+	services := make(map[uint16]sdtService)
+	//original_network_id and reserved byte
+	if len(s.data) < 3 {
+		return services
+	}
+	for d := s.data[3:]; len(d) >= 5; {
+		id := uint16(d[0])<<8 | uint16(d[1])
+		loopLength := int(d[3]&0x0f)<<8 | int(d[4])
+		if 5+loopLength > len(d) {
+			break
+		}
+		var service sdtService
+		forEachDescriptor(d[5:5+loopLength], func(tag byte, data []byte) {
+			if tag != descriptorService || len(data) < 2 {
+				return
+			}
+			service.typ = data[0]
+			providerLength := int(data[1])
+			if 2+providerLength >= len(data) {
+				return
+			}
+			service.provider = dvbString(data[2 : 2+providerLength])
+			data = data[2+providerLength:]
+			nameLength := int(data[0])
+			if 1+nameLength > len(data) {
+				return
+			}
+			service.name = dvbString(data[1 : 1+nameLength])
+		})
+		services[id] = service
+		d = d[5+loopLength:]
+	}
+	return services
+}
+
+// dvbString decodes a DVB SI string (EN 300 468 annex A), character tables
+// other than UTF-8 are approximated as Latin-1
+func dvbString(b []byte) string {
+	if len(b) > 0 && b[0] < 0x20 {
+		switch b[0] {
+		case 0x10:
+			if len(b) < 3 {
+				return ""
+			}
+			b = b[3:]
+		case 0x15:
+			return strings.TrimSpace(string(b[1:]))
+		case 0x1f:
+			if len(b) < 2 {
+				return ""
+			}
+			b = b[2:]
+		default:
+			b = b[1:]
+		}
+	}
+	r := make([]rune, 0, len(b))
+	for _, c := range b {
+		//skip control codes such as emphasis on/off and CR/LF
+		if c < 0x20 || c >= 0x80 && c < 0xa0 {
+			continue
+		}
+		r = append(r, rune(c))
+	}
+	return strings.TrimSpace(string(r))
+}
+
+func streamTypeName(t uint8) string {
+	switch t {
+	case 0x01:
+		return "MPEG-1 video"
+	case 0x02:
+		return "MPEG-2 video"
+	case 0x03:
+		return "MPEG-1 audio"
+	case 0x04:
+		return "MPEG-2 audio"
+	case 0x05:
+		return "private sections"
+	case 0x06:
+		return "private PES"
+	case 0x0f:
+		return "AAC audio"
+	case 0x11:
+		return "LATM AAC audio"
+	case 0x15:
+		return "metadata"
+	case 0x1b:
+		return "H.264 video"
+	case 0x24:
+		return "HEVC video"
+	case 0x81:
+		return "AC-3 audio"
+	case 0x86:
+		return "SCTE-35"
+	case 0x87:
+		return "E-AC-3 audio"
+	}
+	return "unknown"
+}
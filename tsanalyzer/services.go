@@ -0,0 +1,97 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsanalyzer
+
+import (
+	"reflect"
+	"sort"
+)
+
+type ElementaryStream struct {
+	PID        uint16 `json:"pid"`
+	StreamType uint8  `json:"streamtype"`
+	Type       string `json:"type"`
+	Language   string `json:"language,omitempty"`
+}
+
+// Service combines the PAT, PMT and SDT information of a programme
+type Service struct {
+	ProgramNumber uint16             `json:"programnumber"`
+	Name          string             `json:"name,omitempty"`
+	Provider      string             `json:"provider,omitempty"`
+	ServiceType   uint8              `json:"servicetype,omitempty"`
+	PMTPID        uint16             `json:"pmtpid"`
+	PCRPID        uint16             `json:"pcrpid"`
+	Streams       []ElementaryStream `json:"streams"`
+}
+
+func (a *Analyzer) updateSDT(s section) {
+	if int(s.version) != a.sdtVersion {
+		a.sdtVersion = int(s.version)
+		a.sdt = make(map[uint16]sdtService)
+	}
+	for id, service := range parseSDT(s) {
+		if a.sdt[id] != service {
+			a.sdt[id] = service
+			a.psiChanged = true
+		}
+	}
+}
+
+// services builds the service list from the parsed tables, sorted by
+// programme number
+func (a *Analyzer) services() []Service {
+	services := make([]Service, 0, len(a.programs))
+	for _, prog := range a.programs {
+		service := Service{
+			ProgramNumber: prog.number,
+			PMTPID:        prog.pmtPID,
+			PCRPID:        prog.pcrPID,
+			Streams:       make([]ElementaryStream, 0, len(prog.streams)),
+		}
+		if sdt, ok := a.sdt[prog.number]; ok {
+			service.Name = sdt.name
+			service.Provider = sdt.provider
+			service.ServiceType = sdt.typ
+		}
+		for _, es := range prog.streams {
+			service.Streams = append(service.Streams, ElementaryStream{
+				PID:        es.pid,
+				StreamType: es.streamType,
+				Type:       es.typeName,
+				Language:   es.language,
+			})
+		}
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ProgramNumber < services[j].ProgramNumber
+	})
+	return services
+}
+
+// checkServices logs the service list when it changed
+func (a *Analyzer) checkServices() {
+	a.psiChanged = false
+	services := a.services()
+	if reflect.DeepEqual(services, a.lastServices) {
+		return
+	}
+	if a.lastServices == nil {
+		a.logger.Info().Interface("services", services).Msg("service table received")
+	} else {
+		a.logger.Warn().Interface("services", services).Interface("previous", a.lastServices).Msg("service table changed")
+	}
+	a.lastServices = services
+}
+
+// Services returns the programmes in the stream as last logged
+func (a *Analyzer) Services() []Service {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.lastServices
+}
@@ -0,0 +1,217 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsanalyzer
+
+import "time"
+
+// SplitPoint tells whether a segment can start with a packet
+type SplitPoint int
+
+const (
+	SplitNone SplitPoint = iota
+	// start of a video PES packet not flagged as random access point
+	SplitUnit
+	// start of a video PES packet flagged as random access point, or a PCR
+	// packet of a stream without video
+	SplitRandomAccess
+)
+
+// PCR steps larger than this are treated as a discontinuity by the clock
+const splitterMaxPCRStep = time.Second
+
+// Splitter finds the packets a transport stream can be cut at for segmented
+// delivery such as HLS. It follows the PAT and the PMT of the first programme,
+// so segments can be started with them, and keeps a clock running on the
+// PCR. A Splitter is not safe for concurrent use.
+type Splitter struct {
+	sections map[uint16]*sectionBuffer
+	//packets of the table being received and of the last complete one
+	pending  map[uint16][]byte
+	tables   map[uint16][]byte
+	program  uint16
+	pmtPID   int
+	pcrPID   int
+	videoPID int
+	lastPCR  uint64
+	hasPCR   bool
+	clock    uint64
+	//set when a PCR step was skipped, e.g. after a source switch
+	discontinuity bool
+}
+
+func NewSplitter() *Splitter {
+	return &Splitter{
+		sections: make(map[uint16]*sectionBuffer),
+		pending:  make(map[uint16][]byte),
+		tables:   make(map[uint16][]byte),
+		pmtPID:   -1,
+		pcrPID:   -1,
+		videoPID: -1,
+	}
+}
+
+func isVideo(streamType uint8) bool {
+	switch streamType {
+	case 0x01, 0x02, 0x10, 0x1b, 0x24, 0x33, 0x42:
+		return true
+	}
+	return false
+}
+
+func randomAccessIndicator(af []byte) bool {
+	return len(af) > 0 && af[0]&0x40 != 0
+}
+
+// Packet inspects p, a single packet, and returns whether a segment can start
+// with it
+func (s *Splitter) Packet(p []byte) SplitPoint {
+	if len(p) != PacketSize || p[0] != SyncByte {
+		return SplitNone
+	}
+	pk := packet(p)
+	if pk.transportError() {
+		return SplitNone
+	}
+	pid := pk.pid()
+	af := pk.adaptation()
+	pcr, hasPCR := pcr(af)
+	if hasPCR && int(pid) == s.pcrPID {
+		s.advanceClock(pcr)
+	}
+	if pid == PIDPAT || int(pid) == s.pmtPID {
+		s.psiPacket(pk)
+		return SplitNone
+	}
+	switch {
+	case s.videoPID >= 0:
+		if int(pid) != s.videoPID || !pk.unitStart() {
+			return SplitNone
+		}
+		if randomAccessIndicator(af) {
+			return SplitRandomAccess
+		}
+		return SplitUnit
+	case s.pcrPID >= 0 && hasPCR && int(pid) == s.pcrPID:
+		return SplitRandomAccess
+	}
+	return SplitNone
+}
+
+func (s *Splitter) advanceClock(pcr uint64) {
+	if s.hasPCR {
+		diff := (pcr + pcrWrap - s.lastPCR) % pcrWrap
+		if diff <= uint64(splitterMaxPCRStep/time.Millisecond)*pcrHz/1000 {
+			s.clock += diff
+		} else {
+			s.discontinuity = true
+		}
+	}
+	s.lastPCR = pcr
+	s.hasPCR = true
+}
+
+func (s *Splitter) psiPacket(p packet) {
+	pid := p.pid()
+	if p.scrambled() {
+		return
+	}
+	if p.unitStart() {
+		s.pending[pid] = append(s.pending[pid][:0], p...)
+	} else if len(s.pending[pid]) > 0 {
+		s.pending[pid] = append(s.pending[pid], p...)
+	}
+	sb := s.sections[pid]
+	if sb == nil {
+		sb = &sectionBuffer{}
+		s.sections[pid] = sb
+	}
+	sb.push(p, p.payload(), func(data []byte) {
+		if data[1]&0x80 == 0 || crc32(data) != 0 {
+			return
+		}
+		sec, ok := parseSection(data)
+		if !ok || !sec.currentNext {
+			return
+		}
+		switch {
+		case pid == PIDPAT && data[0] == tableIDPAT:
+			s.updatePAT(sec)
+		case int(pid) == s.pmtPID && data[0] == tableIDPMT && sec.tableIDExt == s.program:
+			var prog program
+			if !parsePMT(sec, &prog) {
+				return
+			}
+			s.pcrPID = int(prog.pcrPID)
+			s.videoPID = -1
+			for _, es := range prog.streams {
+				if isVideo(es.streamType) {
+					s.videoPID = int(es.pid)
+					break
+				}
+			}
+		default:
+			return
+		}
+		s.tables[pid] = append([]byte(nil), s.pending[pid]...)
+	})
+}
+
+// updatePAT follows the programme with the lowest number
+func (s *Splitter) updatePAT(sec section) {
+	programs := parsePAT(sec)
+	number, pmtPID := uint16(0), -1
+	for n, pid := range programs {
+		if pmtPID < 0 || n < number {
+			number, pmtPID = n, int(pid)
+		}
+	}
+	if number == s.program && pmtPID == s.pmtPID {
+		return
+	}
+	if s.pmtPID >= 0 {
+		delete(s.tables, uint16(s.pmtPID))
+	}
+	s.program = number
+	s.pmtPID = pmtPID
+	s.pcrPID = -1
+	s.videoPID = -1
+}
+
+// PSI returns the packets of the last PAT and PMT, nil until both are
+// received
+func (s *Splitter) PSI() []byte {
+	if s.pmtPID < 0 {
+		return nil
+	}
+	pat, pmt := s.tables[PIDPAT], s.tables[uint16(s.pmtPID)]
+	if len(pat) == 0 || len(pmt) == 0 {
+		return nil
+	}
+	return append(append([]byte(nil), pat...), pmt...)
+}
+
+// Clock returns the time elapsed on the PCR since the first PCR, steps of
+// over a second, such as discontinuities, are skipped. ok is false until a
+// PCR is received.
+func (s *Splitter) Clock() (d time.Duration, ok bool) {
+	if !s.hasPCR {
+		return 0, false
+	}
+	return time.Duration(s.clock / (pcrHz / 1000000) * uint64(time.Microsecond)), true
+}
+
+// Discontinuity returns whether the PCR jumped since the last call
+func (s *Splitter) Discontinuity() bool {
+	d := s.discontinuity
+	s.discontinuity = false
+	return d
+}
+
+// HasVideo returns whether the followed programme has a video stream
+func (s *Splitter) HasVideo() bool {
+	return s.videoPID >= 0
+}